@@ -7,15 +7,47 @@ import (
 	"math/bits"
 	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
+// SizeClasses is the number of power-of-two size classes served by pooled allocators, 1B -> 64K
+const SizeClasses = 17
+
 var DefaultAllocator = newDefaultAllocer()
 
+var ErrBadSize = errors.New("allocator Put() incorrect buffer size")
+
 type Allocator interface {
 	Get(size int) []byte
 	Put(buf []byte) error
 }
 
+type allocatorHolder struct {
+	Allocator
+}
+
+var currentAllocator atomic.Value
+
+//nolint:gochecknoinits
+func init() {
+	currentAllocator.Store(allocatorHolder{DefaultAllocator})
+}
+
+// SetAllocator replaces the allocator used by Get, Put and managed buffers.
+// Buffers are returned to the allocator that is installed at release time,
+// so it should be called before any buffer is allocated, or with an allocator
+// that wraps the previous one.
+func SetAllocator(allocator Allocator) {
+	if allocator == nil {
+		allocator = DefaultAllocator
+	}
+	currentAllocator.Store(allocatorHolder{allocator})
+}
+
+func CurrentAllocator() Allocator {
+	return currentAllocator.Load().(allocatorHolder).Allocator
+}
+
 // defaultAllocator for incoming frames, optimized to prevent overwriting after zeroing
 type defaultAllocator struct {
 	buffers []sync.Pool
@@ -26,7 +58,7 @@ type defaultAllocator struct {
 // no more than 50%.
 func newDefaultAllocer() Allocator {
 	alloc := new(defaultAllocator)
-	alloc.buffers = make([]sync.Pool, 17) // 1B -> 64K
+	alloc.buffers = make([]sync.Pool, SizeClasses)
 	for k := range alloc.buffers {
 		i := k
 		alloc.buffers[k].New = func() any {
@@ -42,12 +74,7 @@ func (alloc *defaultAllocator) Get(size int) []byte {
 		panic("alloc bad size: " + strconv.Itoa(size))
 	}
 
-	bits := msb(size)
-	if size == 1<<bits {
-		return alloc.buffers[bits].Get().([]byte)[:size]
-	}
-
-	return alloc.buffers[bits+1].Get().([]byte)[:size]
+	return alloc.buffers[sizeClass(size)].Get().([]byte)[:size]
 }
 
 // Put returns a []byte to pool for future use,
@@ -55,7 +82,7 @@ func (alloc *defaultAllocator) Get(size int) []byte {
 func (alloc *defaultAllocator) Put(buf []byte) error {
 	bits := msb(cap(buf))
 	if cap(buf) == 0 || cap(buf) > 65536 || cap(buf) != 1<<bits {
-		return errors.New("allocator Put() incorrect buffer size")
+		return ErrBadSize
 	}
 
 	//nolint
@@ -64,6 +91,15 @@ func (alloc *defaultAllocator) Put(buf []byte) error {
 	return nil
 }
 
+// sizeClass return the index of the smallest class that fits size
+func sizeClass(size int) int {
+	bits := msb(size)
+	if size == 1<<bits {
+		return int(bits)
+	}
+	return int(bits) + 1
+}
+
 // msb return the pos of most significant bit
 func msb(size int) uint16 {
 	return uint16(bits.Len32(uint32(size)) - 1)
@@ -0,0 +1,133 @@
+package buf
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+type ContextAllocator interface {
+	Allocator
+	GetContext(ctx context.Context, size int) ([]byte, error)
+}
+
+type BudgetExceededError struct {
+	Limit       int64
+	Outstanding int64
+	Size        int
+}
+
+func (e *BudgetExceededError) Error() string {
+	return "buffer budget exceeded: " + strconv.FormatInt(e.Outstanding, 10) + " of " + strconv.FormatInt(e.Limit, 10) + " bytes outstanding, need " + strconv.Itoa(e.Size)
+}
+
+var _ ContextAllocator = (*BudgetAllocator)(nil)
+
+// BudgetAllocator limits the total capacity of buffers obtained from upstream and not yet put back.
+//
+// When the limit is reached, GetContext blocks until enough buffers are returned or ctx is done,
+// or fails with *BudgetExceededError if the allocator is not blocking.
+// Get has no way to report an error, so it never waits and admits buffers over the limit, only counting them.
+// New, NewPacket and NewSize allocate with Get, the copy loops of bufio allocate with GetContext
+// by NewContext and NewPacketContext, and so should other paths that must respect the budget.
+type BudgetAllocator struct {
+	upstream    Allocator
+	limit       int64
+	block       bool
+	access      sync.Mutex
+	outstanding int64
+	released    chan struct{}
+}
+
+func NewBudgetAllocator(upstream Allocator, limit int64, block bool) *BudgetAllocator {
+	return &BudgetAllocator{
+		upstream: upstream,
+		limit:    limit,
+		block:    block,
+	}
+}
+
+func (a *BudgetAllocator) Get(size int) []byte {
+	a.access.Lock()
+	a.outstanding += int64(1 << sizeClass(size))
+	a.access.Unlock()
+	return a.adjust(size, a.upstream.Get(size))
+}
+
+func (a *BudgetAllocator) GetContext(ctx context.Context, size int) ([]byte, error) {
+	err := a.reserve(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	return a.adjust(size, a.upstream.Get(size)), nil
+}
+
+func (a *BudgetAllocator) Put(buf []byte) error {
+	err := a.upstream.Put(buf)
+	if err != nil {
+		return err
+	}
+	a.access.Lock()
+	a.outstanding -= int64(cap(buf))
+	if a.released != nil {
+		close(a.released)
+		a.released = nil
+	}
+	a.access.Unlock()
+	return nil
+}
+
+func (a *BudgetAllocator) Limit() int64 {
+	return a.limit
+}
+
+func (a *BudgetAllocator) Outstanding() int64 {
+	a.access.Lock()
+	defer a.access.Unlock()
+	return a.outstanding
+}
+
+func (a *BudgetAllocator) Upstream() any {
+	return a.upstream
+}
+
+func (a *BudgetAllocator) reserve(ctx context.Context, size int) error {
+	reserved := int64(1 << sizeClass(size))
+	for {
+		a.access.Lock()
+		// always admit a single buffer, or a limit below the buffer size would block forever
+		if a.outstanding == 0 || a.outstanding+reserved <= a.limit {
+			a.outstanding += reserved
+			a.access.Unlock()
+			return nil
+		}
+		if !a.block {
+			outstanding := a.outstanding
+			a.access.Unlock()
+			return &BudgetExceededError{
+				Limit:       a.limit,
+				Outstanding: outstanding,
+				Size:        size,
+			}
+		}
+		if a.released == nil {
+			a.released = make(chan struct{})
+		}
+		released := a.released
+		a.access.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (a *BudgetAllocator) adjust(size int, buffer []byte) []byte {
+	if reserved := 1 << sizeClass(size); cap(buffer) != reserved {
+		a.access.Lock()
+		a.outstanding += int64(cap(buffer) - reserved)
+		a.access.Unlock()
+	}
+	return buffer
+}
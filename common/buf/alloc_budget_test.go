@@ -0,0 +1,67 @@
+package buf_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MehranF123/sing/common/buf"
+)
+
+func TestBudgetAllocator(t *testing.T) {
+	allocator := buf.NewBudgetAllocator(buf.DefaultAllocator, buf.BufferSize, false)
+	buf.SetAllocator(allocator)
+	defer buf.SetAllocator(nil)
+	buffer, err := buf.NewContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = buf.NewContext(context.Background())
+	var budgetErr *buf.BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatal("allocated over the budget: ", err)
+	}
+	stackBuffer, err := buf.StackNewContext(context.Background())
+	if !errors.As(err, &budgetErr) {
+		t.Fatal("stack allocation bypassed the budget: ", err)
+	}
+	buffer.Release()
+	stackBuffer, err = buf.StackNewContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stackBuffer.Release()
+	if outstanding := allocator.Outstanding(); outstanding != 0 {
+		t.Fatal("outstanding ", outstanding, " after release")
+	}
+}
+
+func TestBudgetAllocatorBlockingGet(t *testing.T) {
+	allocator := buf.NewBudgetAllocator(buf.DefaultAllocator, buf.BufferSize, true)
+	buf.SetAllocator(allocator)
+	defer buf.SetAllocator(nil)
+	first := buf.New()
+	done := make(chan *buf.Buffer)
+	go func() {
+		done <- buf.New()
+	}()
+	select {
+	case second := <-done:
+		second.Release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get blocked over the budget")
+	}
+	first.Release()
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := buf.NewContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Release()
+	cancel()
+	_, err = buf.NewContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("waited for the budget after cancel: ", err)
+	}
+}
@@ -0,0 +1,126 @@
+package buf
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type SizeClassStats struct {
+	Size             int
+	Gets             uint64
+	Puts             uint64
+	BadPuts          uint64
+	OutstandingBytes int64
+}
+
+type AllocatorStats struct {
+	Classes [SizeClasses]SizeClassStats
+}
+
+func (s AllocatorStats) OutstandingBytes() int64 {
+	var outstanding int64
+	for _, class := range s.Classes {
+		outstanding += class.OutstandingBytes
+	}
+	return outstanding
+}
+
+func (s AllocatorStats) BadPuts() uint64 {
+	var badPuts uint64
+	for _, class := range s.Classes {
+		badPuts += class.BadPuts
+	}
+	return badPuts
+}
+
+type classCounter struct {
+	gets        uint64
+	puts        uint64
+	badPuts     uint64
+	outstanding int64
+}
+
+var _ ContextAllocator = (*InstrumentedAllocator)(nil)
+
+// InstrumentedAllocator counts operations of the upstream allocator for each size class.
+type InstrumentedAllocator struct {
+	classes       [SizeClasses]classCounter
+	upstream      Allocator
+	badPutHandler func(buf []byte, err error)
+}
+
+// NewInstrumentedAllocator wraps upstream, badPutHandler is called with every buffer rejected by upstream and can be nil.
+func NewInstrumentedAllocator(upstream Allocator, badPutHandler func(buf []byte, err error)) *InstrumentedAllocator {
+	return &InstrumentedAllocator{
+		upstream:      upstream,
+		badPutHandler: badPutHandler,
+	}
+}
+
+func (a *InstrumentedAllocator) Get(size int) []byte {
+	buffer := a.upstream.Get(size)
+	a.countGet(buffer)
+	return buffer
+}
+
+func (a *InstrumentedAllocator) GetContext(ctx context.Context, size int) ([]byte, error) {
+	contextAllocator, isContextAllocator := a.upstream.(ContextAllocator)
+	if !isContextAllocator {
+		return a.Get(size), nil
+	}
+	buffer, err := contextAllocator.GetContext(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	a.countGet(buffer)
+	return buffer, nil
+}
+
+func (a *InstrumentedAllocator) countGet(buffer []byte) {
+	class := &a.classes[clampSizeClass(cap(buffer))]
+	atomic.AddUint64(&class.gets, 1)
+	atomic.AddInt64(&class.outstanding, int64(cap(buffer)))
+}
+
+func (a *InstrumentedAllocator) Put(buf []byte) error {
+	class := &a.classes[clampSizeClass(cap(buf))]
+	err := a.upstream.Put(buf)
+	if err != nil {
+		atomic.AddUint64(&class.badPuts, 1)
+		if a.badPutHandler != nil {
+			a.badPutHandler(buf, err)
+		}
+		return err
+	}
+	atomic.AddUint64(&class.puts, 1)
+	atomic.AddInt64(&class.outstanding, -int64(cap(buf)))
+	return nil
+}
+
+func (a *InstrumentedAllocator) Upstream() any {
+	return a.upstream
+}
+
+func (a *InstrumentedAllocator) Stats() AllocatorStats {
+	var stats AllocatorStats
+	for i := range a.classes {
+		class := &a.classes[i]
+		stats.Classes[i] = SizeClassStats{
+			Size:             1 << i,
+			Gets:             atomic.LoadUint64(&class.gets),
+			Puts:             atomic.LoadUint64(&class.puts),
+			BadPuts:          atomic.LoadUint64(&class.badPuts),
+			OutstandingBytes: atomic.LoadInt64(&class.outstanding),
+		}
+	}
+	return stats
+}
+
+func clampSizeClass(size int) int {
+	if size <= 1 {
+		return 0
+	} else if size >= 1<<(SizeClasses-1) {
+		return SizeClasses - 1
+	}
+	return sizeClass(size)
+}
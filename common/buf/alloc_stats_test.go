@@ -0,0 +1,37 @@
+package buf_test
+
+import (
+	"testing"
+
+	"github.com/MehranF123/sing/common/buf"
+)
+
+type rejectingAllocator struct{}
+
+func (rejectingAllocator) Get(size int) []byte {
+	return make([]byte, size)
+}
+
+func (rejectingAllocator) Put(buffer []byte) error {
+	return buf.ErrBadSize
+}
+
+func TestReleaseBadPut(t *testing.T) {
+	var badPuts int
+	allocator := buf.NewInstrumentedAllocator(rejectingAllocator{}, func(buffer []byte, err error) {
+		badPuts++
+	})
+	buf.SetAllocator(allocator)
+	defer buf.SetAllocator(nil)
+	buffer := buf.New()
+	view := buffer.View()
+	buffer.Release()
+	view.Release()
+	buf.NewPacket().Release()
+	if badPuts != 2 {
+		t.Fatal("bad puts ", badPuts, ", expected 2")
+	}
+	if stats := allocator.Stats(); stats.BadPuts() != 2 {
+		t.Fatal("counted bad puts ", stats.BadPuts(), ", expected 2")
+	}
+}
@@ -1,12 +1,14 @@
 package buf
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
 	"io"
 	"net"
 	"strconv"
 	"sync/atomic"
+	"unsafe"
 
 	"github.com/MehranF123/sing/common"
 )
@@ -24,24 +26,56 @@ type Buffer struct {
 	refs    int32
 	managed bool
 	closed  bool
+	shared  *sharedData
+}
+
+type sharedData struct {
+	data []byte
+	refs int32
 }
 
 func New() *Buffer {
-	return &Buffer{
+	return trackBuffer(&Buffer{
 		data:    Get(BufferSize),
 		start:   ReversedHeader,
 		end:     ReversedHeader,
 		managed: true,
-	}
+	})
 }
 
 func NewPacket() *Buffer {
-	return &Buffer{
+	return trackBuffer(&Buffer{
 		data:    Get(UDPBufferSize),
 		start:   ReversedHeader,
 		end:     ReversedHeader,
 		managed: true,
+	})
+}
+
+func NewContext(ctx context.Context) (*Buffer, error) {
+	data, err := GetContext(ctx, BufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return trackBuffer(&Buffer{
+		data:    data,
+		start:   ReversedHeader,
+		end:     ReversedHeader,
+		managed: true,
+	}), nil
+}
+
+func NewPacketContext(ctx context.Context) (*Buffer, error) {
+	data, err := GetContext(ctx, UDPBufferSize)
+	if err != nil {
+		return nil, err
 	}
+	return trackBuffer(&Buffer{
+		data:    data,
+		start:   ReversedHeader,
+		end:     ReversedHeader,
+		managed: true,
+	}), nil
 }
 
 func NewSize(size int) *Buffer {
@@ -50,10 +84,10 @@ func NewSize(size int) *Buffer {
 			data: make([]byte, size),
 		}
 	}
-	return &Buffer{
+	return trackBuffer(&Buffer{
 		data:    Get(size),
 		managed: true,
-	}
+	})
 }
 
 func StackNew() *Buffer {
@@ -80,6 +114,23 @@ func StackNewPacket() *Buffer {
 	}
 }
 
+// StackNewContext is StackNew if no BudgetAllocator is installed, and NewContext otherwise,
+// so the budget applies to buffers that would bypass the allocator.
+func StackNewContext(ctx context.Context) (*Buffer, error) {
+	if _, budgeted := common.Cast[*BudgetAllocator](CurrentAllocator()); !budgeted {
+		return StackNew(), nil
+	}
+	return NewContext(ctx)
+}
+
+// StackNewPacketContext is StackNewPacket if no BudgetAllocator is installed, and NewPacketContext otherwise.
+func StackNewPacketContext(ctx context.Context) (*Buffer, error) {
+	if _, budgeted := common.Cast[*BudgetAllocator](CurrentAllocator()); !budgeted {
+		return StackNewPacket(), nil
+	}
+	return NewPacketContext(ctx)
+}
+
 func StackNewSize(size int) *Buffer {
 	if common.UnsafeBuffer {
 		return &Buffer{
@@ -317,10 +368,65 @@ func (b *Buffer) Release() {
 	if atomic.LoadInt32(&b.refs) > 0 {
 		return
 	}
-	common.Must(Put(b.data))
+	// a rejected put is reported by the allocator, such as to the handler of InstrumentedAllocator,
+	// and the memory is left to the garbage collector
+	if shared := b.loadShared(); shared == nil {
+		_ = Put(b.data)
+	} else if atomic.AddInt32(&shared.refs, -1) == 0 {
+		_ = Put(shared.data)
+	}
+	untrackBuffer(b)
 	*b = Buffer{closed: true}
 }
 
+// View returns a new buffer sharing the content of b without copying.
+// The view has no reserved header and no free space, so it can not grow into memory used by b.
+// The backing memory returns to the pool when b and all of its views are released.
+func (b *Buffer) View() *Buffer {
+	return b.view(b.start, b.end)
+}
+
+// Split returns a view of the first n bytes of b and advances b past them.
+// b loses its reserved header, so prepending to b can not overwrite the view.
+func (b *Buffer) Split(n int) *Buffer {
+	if n < 0 || n > b.Len() {
+		panic("buffer overflow: len " + strconv.Itoa(b.Len()) + ", split " + strconv.Itoa(n))
+	}
+	view := b.view(b.start, b.start+n)
+	b.data = b.data[b.start+n:]
+	b.end -= b.start + n
+	b.start = 0
+	return view
+}
+
+func (b *Buffer) view(start, end int) *Buffer {
+	view := &Buffer{
+		data: b.data[start:end:end],
+		end:  end - start,
+	}
+	if !b.managed {
+		return view
+	}
+	shared := b.loadShared()
+	if shared == nil {
+		// views may be created concurrently, only one of them may install the shared data
+		newShared := &sharedData{data: b.data, refs: 1}
+		if atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&b.shared)), nil, unsafe.Pointer(newShared)) {
+			shared = newShared
+		} else {
+			shared = b.loadShared()
+		}
+	}
+	atomic.AddInt32(&shared.refs, 1)
+	view.managed = true
+	view.shared = shared
+	return trackBuffer(view)
+}
+
+func (b *Buffer) loadShared() *sharedData {
+	return (*sharedData)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&b.shared))))
+}
+
 func (b *Buffer) Cut(start int, end int) *Buffer {
 	b.start += start
 	b.end = len(b.data) - end
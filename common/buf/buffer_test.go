@@ -0,0 +1,131 @@
+package buf_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/MehranF123/sing/common/buf"
+)
+
+// countingAllocator counts the buffers returned to it.
+type countingAllocator struct {
+	access sync.Mutex
+	puts   int
+}
+
+func (a *countingAllocator) Get(size int) []byte {
+	return make([]byte, size)
+}
+
+func (a *countingAllocator) Put(buffer []byte) error {
+	a.access.Lock()
+	a.puts++
+	a.access.Unlock()
+	return nil
+}
+
+func (a *countingAllocator) Puts() int {
+	a.access.Lock()
+	defer a.access.Unlock()
+	return a.puts
+}
+
+func withCountingAllocator(t *testing.T) *countingAllocator {
+	allocator := &countingAllocator{}
+	buf.SetAllocator(allocator)
+	t.Cleanup(func() {
+		buf.SetAllocator(nil)
+	})
+	return allocator
+}
+
+func TestViewReleaseOrder(t *testing.T) {
+	for _, parentFirst := range []bool{true, false} {
+		allocator := withCountingAllocator(t)
+		buffer := buf.New()
+		buffer.Write([]byte("hello world"))
+		first := buffer.View()
+		second := buffer.Split(5)
+		if string(second.Bytes()) != "hello" || string(buffer.Bytes()) != " world" {
+			t.Fatal("split ", string(second.Bytes()), ", left ", string(buffer.Bytes()))
+		}
+		if parentFirst {
+			buffer.Release()
+			first.Release()
+		} else {
+			first.Release()
+			buffer.Release()
+		}
+		if allocator.Puts() != 0 {
+			t.Fatal("put back while a view is alive")
+		}
+		if string(second.Bytes()) != "hello" {
+			t.Fatal("view changed after release: ", string(second.Bytes()))
+		}
+		second.Release()
+		second.Release()
+		if puts := allocator.Puts(); puts != 1 {
+			t.Fatal("puts ", puts, ", expected 1")
+		}
+	}
+}
+
+func TestViewConcurrent(t *testing.T) {
+	allocator := withCountingAllocator(t)
+	buffer := buf.New()
+	buffer.Write([]byte("concurrent"))
+	views := make([]*buf.Buffer, 16)
+	var wg sync.WaitGroup
+	for i := range views {
+		index := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			views[index] = buffer.View()
+		}()
+	}
+	wg.Wait()
+	buffer.Release()
+	for _, view := range views {
+		view.Release()
+	}
+	if puts := allocator.Puts(); puts != 1 {
+		t.Fatal("puts ", puts, ", expected 1")
+	}
+}
+
+func TestSplitHeader(t *testing.T) {
+	buffer := buf.New()
+	defer buffer.Release()
+	buffer.Write([]byte("headerpayload"))
+	view := buffer.Split(6)
+	defer view.Release()
+	if buffer.Start() != 0 {
+		t.Fatal("header room left after split: ", buffer.Start())
+	}
+	if view.Start() != 0 || view.FreeLen() != 0 {
+		t.Fatal("view can grow into the parent")
+	}
+	assertPanic(t, func() {
+		buffer.ExtendHeader(1)
+	})
+	assertPanic(t, func() {
+		buffer.Split(-1)
+	})
+	assertPanic(t, func() {
+		buffer.Split(buffer.Len() + 1)
+	})
+	if string(view.Bytes()) != "header" || string(buffer.Bytes()) != "payload" {
+		t.Fatal("split ", string(view.Bytes()), ", left ", string(buffer.Bytes()))
+	}
+}
+
+func assertPanic(t *testing.T, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	f()
+}
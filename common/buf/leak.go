@@ -0,0 +1,138 @@
+package buf
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MehranF123/sing/common/debug"
+)
+
+// Allocation describes a managed buffer that has not been returned to the allocator yet.
+// Records are only kept in builds with the debug tag.
+type Allocation struct {
+	ID    uint64
+	Size  int
+	Time  time.Time
+	Stack string
+}
+
+type allocationRecord struct {
+	id    uint64
+	size  int
+	time  time.Time
+	stack []uintptr
+}
+
+var (
+	allocationAccess sync.Mutex
+	allocationID     uint64
+	allocations      = make(map[*Buffer]*allocationRecord)
+)
+
+func trackBuffer(buffer *Buffer) *Buffer {
+	if !debug.Enabled {
+		return buffer
+	}
+	record := &allocationRecord{
+		size:  len(buffer.data),
+		time:  time.Now(),
+		stack: make([]uintptr, 32),
+	}
+	record.stack = record.stack[:runtime.Callers(3, record.stack)]
+	allocationAccess.Lock()
+	allocationID++
+	record.id = allocationID
+	allocations[buffer] = record
+	allocationAccess.Unlock()
+	return buffer
+}
+
+func untrackBuffer(buffer *Buffer) {
+	if !debug.Enabled {
+		return
+	}
+	allocationAccess.Lock()
+	delete(allocations, buffer)
+	allocationAccess.Unlock()
+}
+
+// Outstanding returns all managed buffers allocated by New, NewPacket or NewSize
+// that have not been released yet, ordered by allocation.
+// It always returns nil if the debug tag is not set.
+func Outstanding() []Allocation {
+	return outstandingSince(0)
+}
+
+func outstandingSince(id uint64) []Allocation {
+	if !debug.Enabled {
+		return nil
+	}
+	allocationAccess.Lock()
+	records := make([]*allocationRecord, 0, len(allocations))
+	for _, record := range allocations {
+		if record.id > id {
+			records = append(records, record)
+		}
+	}
+	allocationAccess.Unlock()
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].id < records[j].id
+	})
+	result := make([]Allocation, 0, len(records))
+	for _, record := range records {
+		result = append(result, Allocation{
+			ID:    record.id,
+			Size:  record.size,
+			Time:  record.time,
+			Stack: formatStack(record.stack),
+		})
+	}
+	return result
+}
+
+func formatStack(stack []uintptr) string {
+	var builder strings.Builder
+	frames := runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		builder.WriteString(frame.Function)
+		builder.WriteString("\n\t")
+		builder.WriteString(frame.File)
+		builder.WriteString(":")
+		builder.WriteString(strconv.Itoa(frame.Line))
+		builder.WriteString("\n")
+		if !more {
+			break
+		}
+	}
+	return builder.String()
+}
+
+type TestingT interface {
+	Helper()
+	Cleanup(func())
+	Errorf(format string, args ...any)
+}
+
+// LeakCheck fails the test if any managed buffer allocated after this call
+// is still outstanding when the test and its subtests complete.
+// It does nothing if the debug tag is not set.
+func LeakCheck(t TestingT) {
+	t.Helper()
+	if !debug.Enabled {
+		return
+	}
+	allocationAccess.Lock()
+	startID := allocationID
+	allocationAccess.Unlock()
+	t.Cleanup(func() {
+		t.Helper()
+		for _, allocation := range outstandingSince(startID) {
+			t.Errorf("leaked buffer #%d (%d bytes) allocated at:\n%s", allocation.ID, allocation.Size, allocation.Stack)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package buf_test
+
+import (
+	"testing"
+
+	"github.com/MehranF123/sing/common/buf"
+	"github.com/MehranF123/sing/common/debug"
+)
+
+type leakT struct {
+	cleanups []func()
+	errors   int
+}
+
+func (t *leakT) Helper() {}
+
+func (t *leakT) Cleanup(cleanup func()) {
+	t.cleanups = append(t.cleanups, cleanup)
+}
+
+func (t *leakT) Errorf(format string, args ...any) {
+	t.errors++
+}
+
+func (t *leakT) finish() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func TestLeakCheck(t *testing.T) {
+	if !debug.Enabled {
+		t.Skip("buffers are only tracked with the debug tag")
+	}
+	var fakeT leakT
+	buf.LeakCheck(&fakeT)
+	buffer := buf.New()
+	parent := buf.New()
+	_, err := parent.Write([]byte("view"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := parent.View()
+	parent.Release()
+	packet := buf.NewPacket()
+	if outstanding := buf.Outstanding(); len(outstanding) < 3 {
+		t.Fatal("outstanding ", len(outstanding), ", expected at least 3")
+	}
+	fakeT.finish()
+	if fakeT.errors != 3 {
+		t.Fatal("reported ", fakeT.errors, " leaks, expected 3")
+	}
+	buffer.Release()
+	view.Release()
+	packet.Release()
+}
+
+func TestLeakCheckClean(t *testing.T) {
+	if !debug.Enabled {
+		t.Skip("buffers are only tracked with the debug tag")
+	}
+	var fakeT leakT
+	buf.LeakCheck(&fakeT)
+	buffer := buf.New()
+	view := buffer.View()
+	packet := buf.NewPacket()
+	split := packet.Split(0)
+	buffer.Release()
+	view.Release()
+	split.Release()
+	packet.Release()
+	fakeT.finish()
+	if fakeT.errors != 0 {
+		t.Fatal("reported ", fakeT.errors, " leaks of released buffers")
+	}
+}
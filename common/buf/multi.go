@@ -0,0 +1,34 @@
+package buf
+
+func LenMulti(buffers []*Buffer) int {
+	var n int
+	for _, buffer := range buffers {
+		n += buffer.Len()
+	}
+	return n
+}
+
+func ToSliceMulti(buffers []*Buffer) [][]byte {
+	data := make([][]byte, 0, len(buffers))
+	for _, buffer := range buffers {
+		if buffer.IsEmpty() {
+			continue
+		}
+		data = append(data, buffer.Bytes())
+	}
+	return data
+}
+
+func CopyMulti(toBuffer []byte, buffers []*Buffer) int {
+	var n int
+	for _, buffer := range buffers {
+		n += copy(toBuffer[n:], buffer.Bytes())
+	}
+	return n
+}
+
+func ReleaseMulti(buffers []*Buffer) {
+	for _, buffer := range buffers {
+		buffer.Release()
+	}
+}
@@ -1,11 +1,22 @@
 package buf
 
+import "context"
+
 func Get(size int) []byte {
-	return DefaultAllocator.Get(size)
+	return CurrentAllocator().Get(size)
+}
+
+// GetContext is like Get, but honors the budget of a ContextAllocator.
+func GetContext(ctx context.Context, size int) ([]byte, error) {
+	allocator := CurrentAllocator()
+	if contextAllocator, isContextAllocator := allocator.(ContextAllocator); isContextAllocator {
+		return contextAllocator.GetContext(ctx, size)
+	}
+	return allocator.Get(size), nil
 }
 
 func Put(buf []byte) error {
-	return DefaultAllocator.Put(buf)
+	return CurrentAllocator().Put(buf)
 }
 
 func Make(size int) []byte {
@@ -0,0 +1,56 @@
+package bufio
+
+import (
+	"context"
+	"io"
+
+	"github.com/MehranF123/sing/common/buf"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+const DefaultBatchSize = 16
+
+func CopyPacketBatch(dst N.PacketBatchWriter, src N.PacketBatchReader, batchSize int) (n int64, err error) {
+	return copyPacketBatch(context.Background(), dst, src, batchSize, nil)
+}
+
+func copyPacketBatch(ctx context.Context, dst N.PacketBatchWriter, src N.PacketBatchReader, batchSize int, countFunc []N.CountFunc) (n int64, err error) {
+	buffers := make([]*buf.Buffer, batchSize)
+	destinations := make([]M.Socksaddr, batchSize)
+	defer func() {
+		buf.ReleaseMulti(buffers)
+	}()
+	for {
+		for i := range buffers {
+			if buffers[i] != nil {
+				continue
+			}
+			buffers[i], err = buf.NewPacketContext(ctx)
+			if err != nil {
+				return
+			}
+		}
+		var count int
+		count, err = src.ReadPacketBatch(buffers, destinations)
+		if err != nil {
+			return
+		}
+		var dataLen int
+		for _, buffer := range buffers[:count] {
+			if buffer.IsFull() {
+				return n, io.ErrShortBuffer
+			}
+			dataLen += buffer.Len()
+		}
+		err = dst.WritePacketBatch(buffers[:count], destinations[:count])
+		for i := 0; i < count; i++ {
+			buffers[i] = nil
+		}
+		if err != nil {
+			return
+		}
+		n += int64(dataLen)
+		countAll(countFunc, int64(dataLen))
+	}
+}
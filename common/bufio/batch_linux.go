@@ -0,0 +1,200 @@
+package bufio
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"unsafe"
+
+	"github.com/MehranF123/sing/common/buf"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	_ N.PacketBatchReader = (*ExtendedUDPConn)(nil)
+	_ N.PacketBatchWriter = (*ExtendedUDPConn)(nil)
+)
+
+type mmsghdr struct {
+	Hdr unix.Msghdr
+	Len uint32
+}
+
+// batchMessages holds the syscall arguments of a batch, reused across calls through batchMessagesPool.
+type batchMessages struct {
+	iovecs   []unix.Iovec
+	names    []unix.RawSockaddrInet6
+	messages []mmsghdr
+}
+
+var batchMessagesPool = sync.Pool{
+	New: func() any {
+		return new(batchMessages)
+	},
+}
+
+func getBatchMessages(size int) *batchMessages {
+	batch := batchMessagesPool.Get().(*batchMessages)
+	if cap(batch.messages) < size {
+		batch.iovecs = make([]unix.Iovec, size)
+		batch.names = make([]unix.RawSockaddrInet6, size)
+		batch.messages = make([]mmsghdr, size)
+	} else {
+		batch.iovecs = batch.iovecs[:size]
+		batch.names = batch.names[:size]
+		batch.messages = batch.messages[:size]
+	}
+	return batch
+}
+
+func putBatchMessages(batch *batchMessages) {
+	// drop references to packet memory before pooling
+	for i := range batch.messages {
+		batch.iovecs[i] = unix.Iovec{}
+		batch.names[i] = unix.RawSockaddrInet6{}
+		batch.messages[i] = mmsghdr{}
+	}
+	batchMessagesPool.Put(batch)
+}
+
+func (w *ExtendedUDPConn) ReadPacketBatch(buffers []*buf.Buffer, destinations []M.Socksaddr) (n int, err error) {
+	rawConn, err := w.SyscallConn()
+	if err != nil {
+		return
+	}
+	batch := getBatchMessages(len(buffers))
+	defer putBatchMessages(batch)
+	iovecs, names, messages := batch.iovecs, batch.names, batch.messages
+	for i, buffer := range buffers {
+		if buffer.IsFull() {
+			return 0, io.ErrShortBuffer
+		}
+		freeBytes := buffer.FreeBytes()
+		iovecs[i].Base = &freeBytes[0]
+		iovecs[i].SetLen(len(freeBytes))
+		messages[i].Hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		messages[i].Hdr.Namelen = unix.SizeofSockaddrInet6
+		messages[i].Hdr.Iov = &iovecs[i]
+		messages[i].Hdr.SetIovlen(1)
+	}
+	var innerErr error
+	err = rawConn.Read(func(fd uintptr) (done bool) {
+		n, innerErr = mmsg(unix.SYS_RECVMMSG, fd, messages)
+		return innerErr != unix.EAGAIN
+	})
+	if innerErr != nil {
+		err = innerErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		buffers[i].Extend(int(messages[i].Len))
+		destinations[i] = fromRawSockaddr(&names[i])
+	}
+	return
+}
+
+func (w *ExtendedUDPConn) WritePacketBatch(buffers []*buf.Buffer, destinations []M.Socksaddr) error {
+	defer buf.ReleaseMulti(buffers)
+	rawConn, err := w.SyscallConn()
+	if err != nil {
+		return err
+	}
+	connected := w.RemoteAddr() != nil
+	inet4 := M.AddrFromNetAddr(w.LocalAddr()).Is4()
+	batch := getBatchMessages(len(buffers))
+	defer putBatchMessages(batch)
+	iovecs, names, messages := batch.iovecs, batch.names, batch.messages
+	for i, buffer := range buffers {
+		// an empty datagram is sent with no iovec
+		if !buffer.IsEmpty() {
+			iovecs[i].Base = &buffer.Bytes()[0]
+			iovecs[i].SetLen(buffer.Len())
+			messages[i].Hdr.Iov = &iovecs[i]
+			messages[i].Hdr.SetIovlen(1)
+		}
+		if connected {
+			continue
+		}
+		destination := destinations[i]
+		if destination.IsFqdn() {
+			udpAddr, err := net.ResolveUDPAddr("udp", destination.String())
+			if err != nil {
+				return err
+			}
+			destination = M.SocksaddrFromNet(udpAddr)
+		}
+		messages[i].Hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		messages[i].Hdr.Namelen = toRawSockaddr(&names[i], destination, inet4)
+	}
+	for len(messages) > 0 {
+		var writeN int
+		var innerErr error
+		err = rawConn.Write(func(fd uintptr) (done bool) {
+			writeN, innerErr = mmsg(unix.SYS_SENDMMSG, fd, messages)
+			return innerErr != unix.EAGAIN
+		})
+		if innerErr != nil {
+			err = innerErr
+		}
+		if err != nil {
+			return err
+		}
+		messages = messages[writeN:]
+	}
+	return nil
+}
+
+func mmsg(trap uintptr, fd uintptr, messages []mmsghdr) (int, error) {
+	for {
+		r, _, errno := unix.Syscall6(trap, fd, uintptr(unsafe.Pointer(&messages[0])), uintptr(len(messages)), 0, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		} else if errno != 0 {
+			return 0, errno
+		}
+		return int(r), nil
+	}
+}
+
+func toRawSockaddr(raw *unix.RawSockaddrInet6, destination M.Socksaddr, inet4 bool) uint32 {
+	if inet4 && destination.IsIPv4() {
+		raw4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(raw))
+		raw4.Family = unix.AF_INET
+		putPort(&raw4.Port, destination.Port)
+		raw4.Addr = destination.Addr.As4()
+		return unix.SizeofSockaddrInet4
+	}
+	raw.Family = unix.AF_INET6
+	putPort(&raw.Port, destination.Port)
+	raw.Addr = destination.Addr.As16()
+	return unix.SizeofSockaddrInet6
+}
+
+func fromRawSockaddr(raw *unix.RawSockaddrInet6) M.Socksaddr {
+	switch raw.Family {
+	case unix.AF_INET:
+		raw4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(raw))
+		return M.SocksaddrFrom(netip.AddrFrom4(raw4.Addr), getPort(&raw4.Port))
+	case unix.AF_INET6:
+		return M.SocksaddrFrom(netip.AddrFrom16(raw.Addr), getPort(&raw.Port)).Unwrap()
+	default:
+		return M.Socksaddr{}
+	}
+}
+
+func putPort(port *uint16, value uint16) {
+	p := (*[2]byte)(unsafe.Pointer(port))
+	p[0] = byte(value >> 8)
+	p[1] = byte(value)
+}
+
+func getPort(port *uint16) uint16 {
+	p := (*[2]byte)(unsafe.Pointer(port))
+	return uint16(p[0])<<8 | uint16(p[1])
+}
@@ -0,0 +1,243 @@
+package bufio
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MehranF123/sing/common"
+	"github.com/MehranF123/sing/common/buf"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+const (
+	DefaultCoalesceThreshold = 1400
+	DefaultCoalesceDelay     = 5 * time.Millisecond
+)
+
+var _ N.ExtendedWriter = (*CoalescingWriter)(nil)
+
+// CoalescingWriter holds small writes and sends them to upstream in one write,
+// when the held data reaches threshold, after delay since the first held write, or on Flush.
+//
+// Errors of writes sent by the delay are returned by the next call.
+type CoalescingWriter struct {
+	upstream  io.Writer
+	threshold int
+	delay     time.Duration
+	access    sync.Mutex
+	buffer    *buf.Buffer
+	timer     *time.Timer
+	err       error
+}
+
+// NewCoalescingWriter creates a coalescing writer, threshold and delay use the defaults if not positive.
+func NewCoalescingWriter(writer io.Writer, threshold int, delay time.Duration) *CoalescingWriter {
+	if threshold <= 0 {
+		threshold = DefaultCoalesceThreshold
+	}
+	if delay <= 0 {
+		delay = DefaultCoalesceDelay
+	}
+	return &CoalescingWriter{
+		upstream:  writer,
+		threshold: threshold,
+		delay:     delay,
+	}
+}
+
+func (w *CoalescingWriter) Write(p []byte) (n int, err error) {
+	w.access.Lock()
+	defer w.access.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.buffer == nil {
+		if len(p) >= w.threshold {
+			return w.upstream.Write(p)
+		}
+		w.buffer = buf.NewSize(w.threshold)
+		w.timer = time.AfterFunc(w.delay, w.flushDelayed)
+	}
+	if len(p) > w.buffer.FreeLen() {
+		err = w.flush()
+		if err != nil {
+			return
+		}
+		return w.upstream.Write(p)
+	}
+	common.Must1(w.buffer.Write(p))
+	if w.buffer.IsFull() {
+		err = w.flush()
+	}
+	return len(p), err
+}
+
+func (w *CoalescingWriter) WriteBuffer(buffer *buf.Buffer) error {
+	defer buffer.Release()
+	return common.Error(w.Write(buffer.Bytes()))
+}
+
+func (w *CoalescingWriter) Flush() error {
+	w.access.Lock()
+	defer w.access.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return w.flush()
+}
+
+func (w *CoalescingWriter) flushDelayed() {
+	w.access.Lock()
+	defer w.access.Unlock()
+	if w.err == nil {
+		w.err = w.flush()
+	}
+}
+
+func (w *CoalescingWriter) flush() error {
+	if w.buffer == nil {
+		return nil
+	}
+	w.timer.Stop()
+	buffer := w.buffer
+	w.buffer = nil
+	w.timer = nil
+	defer buffer.Release()
+	return common.Error(w.upstream.Write(buffer.Bytes()))
+}
+
+// Release drops held data without writing it.
+func (w *CoalescingWriter) Release() {
+	w.access.Lock()
+	defer w.access.Unlock()
+	if w.buffer != nil {
+		w.timer.Stop()
+		w.buffer.Release()
+		w.buffer = nil
+		w.timer = nil
+	}
+}
+
+func (w *CoalescingWriter) Upstream() any {
+	return w.upstream
+}
+
+var _ N.ExtendedConn = (*CoalescingConn)(nil)
+
+// CoalescingConn coalesces writes to conn until StopCoalescing is called.
+//
+// Held data is flushed before every read, so a handshake waiting for a response never stalls,
+// and payload written right after a protocol header goes out together with it as early data.
+type CoalescingConn struct {
+	net.Conn
+	writer     *CoalescingWriter
+	access     sync.RWMutex
+	active     bool
+	stopOnRead bool
+}
+
+func NewCoalescingConn(conn net.Conn, threshold int, delay time.Duration) *CoalescingConn {
+	return &CoalescingConn{
+		Conn:   conn,
+		writer: NewCoalescingWriter(conn, threshold, delay),
+		active: true,
+	}
+}
+
+// NewEarlyDataConn creates a coalescing conn that stops coalescing at the first read,
+// so a protocol header and the payload written before the first response go out together.
+func NewEarlyDataConn(conn net.Conn) *CoalescingConn {
+	coalescingConn := NewCoalescingConn(conn, 0, 0)
+	coalescingConn.stopOnRead = true
+	return coalescingConn
+}
+
+func (c *CoalescingConn) Read(p []byte) (n int, err error) {
+	err = c.flushRead()
+	if err != nil {
+		return
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *CoalescingConn) ReadBuffer(buffer *buf.Buffer) error {
+	err := c.flushRead()
+	if err != nil {
+		return err
+	}
+	return NewExtendedReader(c.Conn).ReadBuffer(buffer)
+}
+
+func (c *CoalescingConn) flushRead() error {
+	if !c.stopOnRead {
+		return c.Flush()
+	}
+	c.access.RLock()
+	active := c.active
+	c.access.RUnlock()
+	if !active {
+		return nil
+	}
+	return c.StopCoalescing()
+}
+
+func (c *CoalescingConn) Write(p []byte) (n int, err error) {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	if !c.active {
+		return c.Conn.Write(p)
+	}
+	return c.writer.Write(p)
+}
+
+func (c *CoalescingConn) WriteBuffer(buffer *buf.Buffer) error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	if !c.active {
+		return NewExtendedWriter(c.Conn).WriteBuffer(buffer)
+	}
+	return c.writer.WriteBuffer(buffer)
+}
+
+func (c *CoalescingConn) Flush() error {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	if !c.active {
+		return nil
+	}
+	return c.writer.Flush()
+}
+
+// StopCoalescing flushes held data, all later writes go to conn directly.
+func (c *CoalescingConn) StopCoalescing() error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	if !c.active {
+		return nil
+	}
+	c.active = false
+	return c.writer.Flush()
+}
+
+func (c *CoalescingConn) Close() error {
+	c.writer.Release()
+	return c.Conn.Close()
+}
+
+func (c *CoalescingConn) Upstream() any {
+	return c.Conn
+}
+
+func (c *CoalescingConn) ReaderReplaceable() bool {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return !c.active
+}
+
+func (c *CoalescingConn) WriterReplaceable() bool {
+	c.access.RLock()
+	defer c.access.RUnlock()
+	return !c.active
+}
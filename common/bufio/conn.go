@@ -38,34 +38,59 @@ func needReadFromWrapper(dst io.ReaderFrom, src io.Reader) bool {
 }
 
 func Copy(dst io.Writer, src io.Reader) (n int64, err error) {
+	return CopyContext(context.Background(), dst, src)
+}
+
+// CopyContext is Copy with buffers allocated by ctx, which cancels waiting for the buffer budget.
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader) (n int64, err error) {
 	if src == nil {
 		return 0, E.New("nil reader")
 	} else if dst == nil {
 		return 0, E.New("nil writer")
 	}
-	src = N.UnwrapReader(src)
-	dst = N.UnwrapWriter(dst)
+	src, readCounters := UnwrapCountReader(src, nil)
+	dst, writeCounters := UnwrapCountWriter(dst, nil)
+	countFunc := append(readCounters, writeCounters...)
+	if handled, n, err := copyDirect(dst, src, countFunc); handled {
+		return n, err
+	}
 	if wt, ok := src.(io.WriterTo); ok {
+		if len(countFunc) > 0 {
+			dst = NewCounterWriter(dst, countFunc)
+		}
 		return wt.WriteTo(dst)
 	}
 	if rt, ok := dst.(io.ReaderFrom); ok {
 		if needReadFromWrapper(rt, src) {
 			src = &readOnlyReader{src}
 		}
+		if len(countFunc) > 0 {
+			src = NewCounterReader(src, countFunc)
+		}
 		return rt.ReadFrom(src)
 	}
-	return CopyExtended(NewExtendedWriter(dst), NewExtendedReader(src))
+	if len(countFunc) > 0 {
+		return CopyExtendedContext(ctx, NewCounterWriter(dst, countFunc), NewExtendedReader(src))
+	}
+	return CopyExtendedContext(ctx, NewExtendedWriter(dst), NewExtendedReader(src))
 }
 
 func CopyExtended(dst N.ExtendedWriter, src N.ExtendedReader) (n int64, err error) {
+	return CopyExtendedContext(context.Background(), dst, src)
+}
+
+func CopyExtendedContext(ctx context.Context, dst N.ExtendedWriter, src N.ExtendedReader) (n int64, err error) {
 	unsafeSrc, srcUnsafe := common.Cast[N.ThreadSafeReader](src)
 	_, dstUnsafe := common.Cast[N.ThreadUnsafeWriter](dst)
 	if srcUnsafe {
 		return CopyExtendedWithSrcBuffer(dst, unsafeSrc)
 	} else if dstUnsafe {
-		return CopyExtendedWithPool(dst, src)
+		return copyExtendedWithPool(ctx, dst, src)
+	}
+	_buffer, err := buf.StackNewContext(ctx)
+	if err != nil {
+		return
 	}
-	_buffer := buf.StackNew()
 	defer common.KeepAlive(_buffer)
 	buffer := common.Dup(_buffer)
 	defer buffer.Release()
@@ -114,8 +139,16 @@ func CopyExtendedWithSrcBuffer(dst N.ExtendedWriter, src N.ThreadSafeReader) (n
 }
 
 func CopyExtendedWithPool(dst N.ExtendedWriter, src N.ExtendedReader) (n int64, err error) {
+	return copyExtendedWithPool(context.Background(), dst, src)
+}
+
+func copyExtendedWithPool(ctx context.Context, dst N.ExtendedWriter, src N.ExtendedReader) (n int64, err error) {
 	for {
-		buffer := buf.New()
+		var buffer *buf.Buffer
+		buffer, err = buf.NewContext(ctx)
+		if err != nil {
+			return
+		}
 		readBufferRaw := buffer.Slice()
 		readBuffer := buf.With(readBufferRaw[:cap(readBufferRaw)-1024])
 		readBuffer.Reset()
@@ -140,25 +173,81 @@ func CopyConn(ctx context.Context, conn net.Conn, dest net.Conn) error {
 	err := task.Run(ctx, func() error {
 		defer rw.CloseRead(conn)
 		defer rw.CloseWrite(dest)
-		return common.Error(Copy(dest, conn))
+		return common.Error(CopyContext(ctx, dest, conn))
 	}, func() error {
 		defer rw.CloseRead(dest)
 		defer rw.CloseWrite(conn)
-		return common.Error(Copy(conn, dest))
+		return common.Error(CopyContext(ctx, conn, dest))
 	})
 	return err
 }
 
+// CopyConnTimeout is CopyConn closing both conns after no read or write completed in either direction for idleTimeout,
+// which is disabled if not positive.
+// Once one direction has finished, halfCloseTimeout replaces idleTimeout if it is positive.
+func CopyConnTimeout(ctx context.Context, conn net.Conn, dest net.Conn, idleTimeout time.Duration, halfCloseTimeout time.Duration) error {
+	defer common.Close(conn, dest)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := newIdleTimer(ctx, cancel, idleTimeout)
+	countFunc := []N.CountFunc{timer.Update}
+	countedConn := NewCounterConn(conn, countFunc, countFunc)
+	countedDest := NewCounterConn(dest, countFunc, countFunc)
+	onFinish := func(err error) {
+		if err != nil {
+			cancel()
+		} else if halfCloseTimeout > 0 {
+			timer.SetTimeout(halfCloseTimeout)
+		}
+	}
+	err := task.Run(ctx, func() error {
+		defer rw.CloseRead(conn)
+		defer rw.CloseWrite(dest)
+		err := common.Error(CopyContext(ctx, countedDest, countedConn))
+		onFinish(err)
+		return err
+	}, func() error {
+		defer rw.CloseRead(dest)
+		defer rw.CloseWrite(conn)
+		err := common.Error(CopyContext(ctx, countedConn, countedDest))
+		onFinish(err)
+		return err
+	})
+	if timer.TimedOut() {
+		return E.Cause(os.ErrDeadlineExceeded, "idle timeout")
+	}
+	return err
+}
+
 func CopyPacket(dst N.PacketWriter, src N.PacketReader) (n int64, err error) {
+	return CopyPacketContext(context.Background(), dst, src)
+}
+
+// CopyPacketContext is CopyPacket with buffers allocated by ctx, which cancels waiting for the buffer budget.
+func CopyPacketContext(ctx context.Context, dst N.PacketWriter, src N.PacketReader) (n int64, err error) {
+	src, readCounters := UnwrapCountPacketReader(src, nil)
+	dst, writeCounters := UnwrapCountPacketWriter(dst, nil)
+	countFunc := append(readCounters, writeCounters...)
+	batchSrc, srcBatch := src.(N.PacketBatchReader)
+	batchDst, dstBatch := dst.(N.PacketBatchWriter)
+	if srcBatch && dstBatch {
+		return copyPacketBatch(ctx, batchDst, batchSrc, DefaultBatchSize, countFunc)
+	}
+	if len(countFunc) > 0 {
+		dst = NewCounterPacketWriter(dst, countFunc)
+	}
 	unsafeSrc, srcUnsafe := common.Cast[N.ThreadSafePacketReader](src)
 	_, dstUnsafe := common.Cast[N.ThreadUnsafeWriter](dst)
 	if srcUnsafe {
 		return CopyPacketWithSrcBuffer(dst, unsafeSrc)
 	} else if dstUnsafe {
-		return CopyPacketWithPool(dst, src)
+		return copyPacketWithPool(ctx, dst, src)
 	}
 
-	_buffer := buf.StackNewPacket()
+	_buffer, err := buf.StackNewPacketContext(ctx)
+	if err != nil {
+		return
+	}
 	defer common.KeepAlive(_buffer)
 	buffer := common.Dup(_buffer)
 	defer buffer.Release()
@@ -184,15 +273,31 @@ func CopyPacket(dst N.PacketWriter, src N.PacketReader) (n int64, err error) {
 }
 
 func CopyPacketTimeout(dst N.PacketWriter, src N.TimeoutPacketReader, timeout time.Duration) (n int64, err error) {
-	unsafeSrc, srcUnsafe := common.Cast[N.ThreadSafePacketReader](src)
+	return CopyPacketTimeoutContext(context.Background(), dst, src, timeout)
+}
+
+// CopyPacketTimeoutContext is CopyPacketTimeout with buffers allocated by ctx.
+//
+// Packets are read from src unwrapped through counters, while read deadlines are still set on src.
+func CopyPacketTimeoutContext(ctx context.Context, dst N.PacketWriter, src N.TimeoutPacketReader, timeout time.Duration) (n int64, err error) {
+	reader, countFunc := UnwrapCountPacketReader(src, nil)
+	dst, writeCounters := UnwrapCountPacketWriter(dst, nil)
+	countFunc = append(countFunc, writeCounters...)
+	if len(countFunc) > 0 {
+		dst = NewCounterPacketWriter(dst, countFunc)
+	}
+	unsafeSrc, srcUnsafe := common.Cast[N.ThreadSafePacketReader](reader)
 	_, dstUnsafe := common.Cast[N.ThreadUnsafeWriter](dst)
 	if srcUnsafe {
 		return CopyPacketWithSrcBufferTimeout(dst, unsafeSrc, src, timeout)
 	} else if dstUnsafe {
-		return CopyPacketWithPoolTimeout(dst, src, timeout)
+		return copyPacketWithPoolTimeout(ctx, dst, reader, src, timeout)
 	}
 
-	_buffer := buf.StackNewPacket()
+	_buffer, err := buf.StackNewPacketContext(ctx)
+	if err != nil {
+		return
+	}
 	defer common.KeepAlive(_buffer)
 	buffer := common.Dup(_buffer)
 	defer buffer.Release()
@@ -205,7 +310,7 @@ func CopyPacketTimeout(dst N.PacketWriter, src N.TimeoutPacketReader, timeout ti
 		if err != nil {
 			return
 		}
-		destination, err = src.ReadPacket(buffer)
+		destination, err = reader.ReadPacket(buffer)
 		if err != nil {
 			return
 		}
@@ -262,9 +367,17 @@ func CopyPacketWithSrcBufferTimeout(dest N.PacketWriter, src N.ThreadSafePacketR
 }
 
 func CopyPacketWithPool(dest N.PacketWriter, src N.PacketReader) (n int64, err error) {
+	return copyPacketWithPool(context.Background(), dest, src)
+}
+
+func copyPacketWithPool(ctx context.Context, dest N.PacketWriter, src N.PacketReader) (n int64, err error) {
 	var destination M.Socksaddr
 	for {
-		buffer := buf.NewPacket()
+		var buffer *buf.Buffer
+		buffer, err = buf.NewPacketContext(ctx)
+		if err != nil {
+			return
+		}
 		destination, err = src.ReadPacket(buffer)
 		if err != nil {
 			buffer.Release()
@@ -285,10 +398,18 @@ func CopyPacketWithPool(dest N.PacketWriter, src N.PacketReader) (n int64, err e
 }
 
 func CopyPacketWithPoolTimeout(dest N.PacketWriter, src N.TimeoutPacketReader, timeout time.Duration) (n int64, err error) {
+	return copyPacketWithPoolTimeout(context.Background(), dest, src, src, timeout)
+}
+
+func copyPacketWithPoolTimeout(ctx context.Context, dest N.PacketWriter, src N.PacketReader, tSrc N.TimeoutPacketReader, timeout time.Duration) (n int64, err error) {
 	var destination M.Socksaddr
 	for {
-		buffer := buf.NewPacket()
-		err = src.SetReadDeadline(time.Now().Add(timeout))
+		err = tSrc.SetReadDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return
+		}
+		var buffer *buf.Buffer
+		buffer, err = buf.NewPacketContext(ctx)
 		if err != nil {
 			return
 		}
@@ -314,18 +435,18 @@ func CopyPacketWithPoolTimeout(dest N.PacketWriter, src N.TimeoutPacketReader, t
 func CopyPacketConn(ctx context.Context, conn N.PacketConn, dest N.PacketConn) error {
 	defer common.Close(conn, dest)
 	return task.Any(ctx, func(ctx context.Context) error {
-		return common.Error(CopyPacket(dest, conn))
+		return common.Error(CopyPacketContext(ctx, dest, conn))
 	}, func(ctx context.Context) error {
-		return common.Error(CopyPacket(conn, dest))
+		return common.Error(CopyPacketContext(ctx, conn, dest))
 	})
 }
 
 func CopyPacketConnTimeout(ctx context.Context, conn N.PacketConn, dest N.PacketConn, timeout time.Duration) error {
 	defer common.Close(conn, dest)
 	return task.Any(ctx, func(ctx context.Context) error {
-		return common.Error(CopyPacketTimeout(dest, conn, timeout))
+		return common.Error(CopyPacketTimeoutContext(ctx, dest, conn, timeout))
 	}, func(ctx context.Context) error {
-		return common.Error(CopyPacketTimeout(conn, dest, timeout))
+		return common.Error(CopyPacketTimeoutContext(ctx, conn, dest, timeout))
 	})
 }
 
@@ -441,6 +562,10 @@ func (c *UnbindPacketConn) WritePacket(buffer *buf.Buffer, _ M.Socksaddr) error
 	return c.ExtendedConn.WriteBuffer(buffer)
 }
 
+func (c *UnbindPacketConn) WriteVectorisedPacket(buffers []*buf.Buffer, _ M.Socksaddr) error {
+	return NewVectorisedWriter(c.ExtendedConn).WriteVectorised(buffers)
+}
+
 func (c *UnbindPacketConn) Upstream() any {
 	return c.ExtendedConn
 }
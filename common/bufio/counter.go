@@ -0,0 +1,316 @@
+package bufio
+
+import (
+	"io"
+	"net"
+
+	"github.com/MehranF123/sing/common/buf"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+func countAll(countFunc []N.CountFunc, n int64) {
+	for _, counter := range countFunc {
+		counter(n)
+	}
+}
+
+var _ N.ReadCounter = (*CounterReader)(nil)
+
+type CounterReader struct {
+	N.ExtendedReader
+	countFunc []N.CountFunc
+}
+
+func NewCounterReader(reader io.Reader, countFunc []N.CountFunc) *CounterReader {
+	return &CounterReader{NewExtendedReader(reader), countFunc}
+}
+
+func (r *CounterReader) Read(p []byte) (n int, err error) {
+	n, err = r.ExtendedReader.Read(p)
+	if n > 0 {
+		countAll(r.countFunc, int64(n))
+	}
+	return
+}
+
+func (r *CounterReader) ReadBuffer(buffer *buf.Buffer) error {
+	err := r.ExtendedReader.ReadBuffer(buffer)
+	if err != nil {
+		return err
+	}
+	countAll(r.countFunc, int64(buffer.Len()))
+	return nil
+}
+
+func (r *CounterReader) UnwrapReader() (io.Reader, []N.CountFunc) {
+	return r.ExtendedReader, r.countFunc
+}
+
+func (r *CounterReader) Upstream() any {
+	return r.ExtendedReader
+}
+
+func (r *CounterReader) ReaderReplaceable() bool {
+	return false
+}
+
+var _ N.WriteCounter = (*CounterWriter)(nil)
+
+type CounterWriter struct {
+	N.ExtendedWriter
+	countFunc []N.CountFunc
+}
+
+func NewCounterWriter(writer io.Writer, countFunc []N.CountFunc) *CounterWriter {
+	return &CounterWriter{NewExtendedWriter(writer), countFunc}
+}
+
+func (w *CounterWriter) Write(p []byte) (n int, err error) {
+	n, err = w.ExtendedWriter.Write(p)
+	if n > 0 {
+		countAll(w.countFunc, int64(n))
+	}
+	return
+}
+
+func (w *CounterWriter) WriteBuffer(buffer *buf.Buffer) error {
+	dataLen := int64(buffer.Len())
+	err := w.ExtendedWriter.WriteBuffer(buffer)
+	if err != nil {
+		return err
+	}
+	countAll(w.countFunc, dataLen)
+	return nil
+}
+
+func (w *CounterWriter) UnwrapWriter() (io.Writer, []N.CountFunc) {
+	return w.ExtendedWriter, w.countFunc
+}
+
+func (w *CounterWriter) Upstream() any {
+	return w.ExtendedWriter
+}
+
+func (w *CounterWriter) WriterReplaceable() bool {
+	return false
+}
+
+var (
+	_ N.ReadCounter  = (*CounterConn)(nil)
+	_ N.WriteCounter = (*CounterConn)(nil)
+)
+
+// CounterConn counts bytes read from and written to conn, the counters are called as data moves.
+//
+// Copy unwraps it through UnwrapCountReader and UnwrapCountWriter and counts on the fast path of conn.
+type CounterConn struct {
+	N.ExtendedConn
+	readCounter  []N.CountFunc
+	writeCounter []N.CountFunc
+}
+
+func NewCounterConn(conn net.Conn, readCounter []N.CountFunc, writeCounter []N.CountFunc) *CounterConn {
+	return &CounterConn{NewExtendedConn(conn), readCounter, writeCounter}
+}
+
+func (c *CounterConn) Read(p []byte) (n int, err error) {
+	n, err = c.ExtendedConn.Read(p)
+	if n > 0 {
+		countAll(c.readCounter, int64(n))
+	}
+	return
+}
+
+func (c *CounterConn) ReadBuffer(buffer *buf.Buffer) error {
+	err := c.ExtendedConn.ReadBuffer(buffer)
+	if err != nil {
+		return err
+	}
+	countAll(c.readCounter, int64(buffer.Len()))
+	return nil
+}
+
+func (c *CounterConn) Write(p []byte) (n int, err error) {
+	n, err = c.ExtendedConn.Write(p)
+	if n > 0 {
+		countAll(c.writeCounter, int64(n))
+	}
+	return
+}
+
+func (c *CounterConn) WriteBuffer(buffer *buf.Buffer) error {
+	dataLen := int64(buffer.Len())
+	err := c.ExtendedConn.WriteBuffer(buffer)
+	if err != nil {
+		return err
+	}
+	countAll(c.writeCounter, dataLen)
+	return nil
+}
+
+func (c *CounterConn) WriteVectorised(buffers []*buf.Buffer) error {
+	dataLen := int64(buf.LenMulti(buffers))
+	err := NewVectorisedWriter(c.ExtendedConn).WriteVectorised(buffers)
+	if err != nil {
+		return err
+	}
+	countAll(c.writeCounter, dataLen)
+	return nil
+}
+
+func (c *CounterConn) UnwrapReader() (io.Reader, []N.CountFunc) {
+	return c.ExtendedConn, c.readCounter
+}
+
+func (c *CounterConn) UnwrapWriter() (io.Writer, []N.CountFunc) {
+	return c.ExtendedConn, c.writeCounter
+}
+
+func (c *CounterConn) Upstream() any {
+	return c.ExtendedConn
+}
+
+func (c *CounterConn) ReaderReplaceable() bool {
+	return false
+}
+
+func (c *CounterConn) WriterReplaceable() bool {
+	return false
+}
+
+var _ N.PacketWriteCounter = (*CounterPacketWriter)(nil)
+
+type CounterPacketWriter struct {
+	N.PacketWriter
+	countFunc []N.CountFunc
+}
+
+func NewCounterPacketWriter(writer N.PacketWriter, countFunc []N.CountFunc) *CounterPacketWriter {
+	return &CounterPacketWriter{writer, countFunc}
+}
+
+func (w *CounterPacketWriter) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	dataLen := int64(buffer.Len())
+	err := w.PacketWriter.WritePacket(buffer, destination)
+	if err != nil {
+		return err
+	}
+	countAll(w.countFunc, dataLen)
+	return nil
+}
+
+func (w *CounterPacketWriter) UnwrapPacketWriter() (N.PacketWriter, []N.CountFunc) {
+	return w.PacketWriter, w.countFunc
+}
+
+func (w *CounterPacketWriter) Upstream() any {
+	return w.PacketWriter
+}
+
+func (w *CounterPacketWriter) WriterReplaceable() bool {
+	return false
+}
+
+var (
+	_ N.PacketReadCounter  = (*CounterPacketConn)(nil)
+	_ N.PacketWriteCounter = (*CounterPacketConn)(nil)
+)
+
+type CounterPacketConn struct {
+	N.PacketConn
+	readCounter  []N.CountFunc
+	writeCounter []N.CountFunc
+}
+
+func NewCounterPacketConn(conn N.PacketConn, readCounter []N.CountFunc, writeCounter []N.CountFunc) *CounterPacketConn {
+	return &CounterPacketConn{conn, readCounter, writeCounter}
+}
+
+func (c *CounterPacketConn) ReadPacket(buffer *buf.Buffer) (destination M.Socksaddr, err error) {
+	destination, err = c.PacketConn.ReadPacket(buffer)
+	if err != nil {
+		return
+	}
+	countAll(c.readCounter, int64(buffer.Len()))
+	return
+}
+
+func (c *CounterPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	dataLen := int64(buffer.Len())
+	err := c.PacketConn.WritePacket(buffer, destination)
+	if err != nil {
+		return err
+	}
+	countAll(c.writeCounter, dataLen)
+	return nil
+}
+
+func (c *CounterPacketConn) UnwrapPacketReader() (N.PacketReader, []N.CountFunc) {
+	return c.PacketConn, c.readCounter
+}
+
+func (c *CounterPacketConn) UnwrapPacketWriter() (N.PacketWriter, []N.CountFunc) {
+	return c.PacketConn, c.writeCounter
+}
+
+func (c *CounterPacketConn) Upstream() any {
+	return c.PacketConn
+}
+
+func (c *CounterPacketConn) ReaderReplaceable() bool {
+	return false
+}
+
+func (c *CounterPacketConn) WriterReplaceable() bool {
+	return false
+}
+
+// UnwrapCountReader unwraps reader like N.UnwrapReader, and also through counters, whose count functions are collected.
+func UnwrapCountReader(reader io.Reader, countFunc []N.CountFunc) (io.Reader, []N.CountFunc) {
+	if counter, isCounter := reader.(N.ReadCounter); isCounter {
+		upstream, upstreamCountFunc := counter.UnwrapReader()
+		return UnwrapCountReader(upstream, append(countFunc, upstreamCountFunc...))
+	}
+	if u, ok := reader.(N.ReaderWithUpstream); ok && u.ReaderReplaceable() {
+		return UnwrapCountReader(u.Upstream().(io.Reader), countFunc)
+	}
+	return reader, countFunc
+}
+
+func UnwrapCountWriter(writer io.Writer, countFunc []N.CountFunc) (io.Writer, []N.CountFunc) {
+	if counter, isCounter := writer.(N.WriteCounter); isCounter {
+		upstream, upstreamCountFunc := counter.UnwrapWriter()
+		return UnwrapCountWriter(upstream, append(countFunc, upstreamCountFunc...))
+	}
+	if u, ok := writer.(N.WriterWithUpstream); ok && u.WriterReplaceable() {
+		return UnwrapCountWriter(u.Upstream().(io.Writer), countFunc)
+	}
+	return writer, countFunc
+}
+
+func UnwrapCountPacketReader(reader N.PacketReader, countFunc []N.CountFunc) (N.PacketReader, []N.CountFunc) {
+	if counter, isCounter := reader.(N.PacketReadCounter); isCounter {
+		upstream, upstreamCountFunc := counter.UnwrapPacketReader()
+		return UnwrapCountPacketReader(upstream, append(countFunc, upstreamCountFunc...))
+	}
+	if u, ok := reader.(N.ReaderWithUpstream); ok && u.ReaderReplaceable() {
+		if upstream, isPacketReader := u.Upstream().(N.PacketReader); isPacketReader {
+			return UnwrapCountPacketReader(upstream, countFunc)
+		}
+	}
+	return reader, countFunc
+}
+
+func UnwrapCountPacketWriter(writer N.PacketWriter, countFunc []N.CountFunc) (N.PacketWriter, []N.CountFunc) {
+	if counter, isCounter := writer.(N.PacketWriteCounter); isCounter {
+		upstream, upstreamCountFunc := counter.UnwrapPacketWriter()
+		return UnwrapCountPacketWriter(upstream, append(countFunc, upstreamCountFunc...))
+	}
+	if u, ok := writer.(N.WriterWithUpstream); ok && u.WriterReplaceable() {
+		if upstream, isPacketWriter := u.Upstream().(N.PacketWriter); isPacketWriter {
+			return UnwrapCountPacketWriter(upstream, countFunc)
+		}
+	}
+	return writer, countFunc
+}
@@ -0,0 +1,193 @@
+package bufio
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/MehranF123/sing/common/buf"
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+// DefaultFramedMaxLength is the default limit of the payload length of received frames.
+const DefaultFramedMaxLength = 64 * 1024
+
+// FrameTooLargeError is returned when a received frame is longer than the limit of the conn.
+type FrameTooLargeError struct {
+	Length    int
+	MaxLength int
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return "frame too large: " + strconv.Itoa(e.Length) + " > " + strconv.Itoa(e.MaxLength)
+}
+
+var (
+	_ N.NetPacketConn          = (*FramedPacketConn)(nil)
+	_ N.ThreadSafePacketReader = (*FramedPacketConn)(nil)
+)
+
+// FramedPacketConn sends packets over a stream, each framed as
+// an optional address written by serializer, a big endian length and the payload.
+type FramedPacketConn struct {
+	net.Conn
+	lengthSize  int
+	serializer  *M.Serializer
+	destination M.Socksaddr
+	maxLength   int
+}
+
+// NewFramedPacketConn creates a framed packet conn with a length prefix of lengthSize bytes, which is 1, 2 or 4.
+//
+// If serializer is nil, frames carry no address, reads return destination and writes ignore their destination.
+func NewFramedPacketConn(conn net.Conn, lengthSize int, serializer *M.Serializer, destination M.Socksaddr) (*FramedPacketConn, error) {
+	switch lengthSize {
+	case 1, 2, 4:
+	default:
+		return nil, E.New("invalid length prefix size: ", lengthSize)
+	}
+	return &FramedPacketConn{
+		Conn:        conn,
+		lengthSize:  lengthSize,
+		serializer:  serializer,
+		destination: destination,
+		maxLength:   DefaultFramedMaxLength,
+	}, nil
+}
+
+// SetMaxFrameLength sets the limit of the payload length of received frames,
+// a received frame over it closes the conn and fails with *FrameTooLargeError.
+// A limit that is not positive restores DefaultFramedMaxLength.
+func (c *FramedPacketConn) SetMaxFrameLength(maxLength int) {
+	if maxLength <= 0 {
+		maxLength = DefaultFramedMaxLength
+	}
+	c.maxLength = maxLength
+}
+
+func (c *FramedPacketConn) readHeader() (destination M.Socksaddr, length int, err error) {
+	if c.serializer != nil {
+		destination, err = c.serializer.ReadAddrPort(c.Conn)
+		if err != nil {
+			return
+		}
+	} else {
+		destination = c.destination
+	}
+	var lengthBytes [4]byte
+	_, err = io.ReadFull(c.Conn, lengthBytes[:c.lengthSize])
+	if err != nil {
+		return
+	}
+	switch c.lengthSize {
+	case 1:
+		length = int(lengthBytes[0])
+	case 2:
+		length = int(binary.BigEndian.Uint16(lengthBytes[:]))
+	default:
+		length = int(binary.BigEndian.Uint32(lengthBytes[:]))
+	}
+	if length > c.maxLength {
+		// the payload is not drained, so the stream can not be resynchronized
+		c.Conn.Close()
+		err = &FrameTooLargeError{length, c.maxLength}
+	}
+	return
+}
+
+func (c *FramedPacketConn) ReadPacket(buffer *buf.Buffer) (destination M.Socksaddr, err error) {
+	destination, length, err := c.readHeader()
+	if err != nil {
+		return
+	}
+	if buffer.FreeLen() < length {
+		_, err = io.CopyN(io.Discard, c.Conn, int64(length))
+		if err != nil {
+			return M.Socksaddr{}, err
+		}
+		return M.Socksaddr{}, io.ErrShortBuffer
+	}
+	_, err = buffer.ReadFullFrom(c.Conn, length)
+	return
+}
+
+func (c *FramedPacketConn) ReadPacketThreadSafe() (buffer *buf.Buffer, destination M.Socksaddr, err error) {
+	destination, length, err := c.readHeader()
+	if err != nil {
+		return
+	}
+	buffer = buf.NewSize(buf.ReversedHeader + length)
+	buffer.Resize(buf.ReversedHeader, 0)
+	_, err = buffer.ReadFullFrom(c.Conn, length)
+	if err != nil {
+		buffer.Release()
+		return nil, M.Socksaddr{}, err
+	}
+	return
+}
+
+func (c *FramedPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	length := buffer.Len()
+	if c.lengthSize < 4 && length >= 1<<(8*c.lengthSize) {
+		buffer.Release()
+		return E.New("packet too large: ", length)
+	}
+	var header *buf.Buffer
+	if c.serializer != nil {
+		header = buf.NewSize(c.serializer.AddrPortLen(destination) + c.lengthSize)
+		err := c.serializer.WriteAddrPort(header, destination)
+		if err != nil {
+			header.Release()
+			buffer.Release()
+			return err
+		}
+	} else {
+		header = buf.NewSize(c.lengthSize)
+	}
+	lengthBytes := header.Extend(c.lengthSize)
+	switch c.lengthSize {
+	case 1:
+		lengthBytes[0] = byte(length)
+	case 2:
+		binary.BigEndian.PutUint16(lengthBytes, uint16(length))
+	default:
+		binary.BigEndian.PutUint32(lengthBytes, uint32(length))
+	}
+	return NewVectorisedWriter(c.Conn).WriteVectorised([]*buf.Buffer{header, buffer})
+}
+
+func (c *FramedPacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	buffer := buf.With(p)
+	destination, err := c.ReadPacket(buffer)
+	if err != nil {
+		return
+	}
+	n = buffer.Len()
+	if destination.IsValid() {
+		addr = destination.UDPAddr()
+	}
+	return
+}
+
+func (c *FramedPacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	err = c.WritePacket(buf.As(p), M.SocksaddrFromNet(addr))
+	if err == nil {
+		n = len(p)
+	}
+	return
+}
+
+func (c *FramedPacketConn) Upstream() any {
+	return c.Conn
+}
+
+func (c *FramedPacketConn) ReaderReplaceable() bool {
+	return false
+}
+
+func (c *FramedPacketConn) WriterReplaceable() bool {
+	return false
+}
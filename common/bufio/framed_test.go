@@ -0,0 +1,47 @@
+package bufio_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MehranF123/sing/common/buf"
+	"github.com/MehranF123/sing/common/bufio"
+	M "github.com/MehranF123/sing/common/metadata"
+)
+
+func newFramedPair(t *testing.T) (*bufio.FramedPacketConn, *bufio.FramedPacketConn) {
+	left, right := bufio.Pipe()
+	t.Cleanup(func() {
+		left.Close()
+		right.Close()
+	})
+	destination := M.ParseSocksaddr("127.0.0.1:53")
+	leftConn, err := bufio.NewFramedPacketConn(left, 2, nil, destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rightConn, err := bufio.NewFramedPacketConn(right, 2, nil, destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leftConn, rightConn
+}
+
+func TestFramedMaxFrameLength(t *testing.T) {
+	writer, reader := newFramedPair(t)
+	reader.SetMaxFrameLength(0)
+	go writer.WritePacket(buf.As(make([]byte, 1000)), M.Socksaddr{})
+	buffer, _, err := reader.ReadPacketThreadSafe()
+	if err != nil {
+		t.Fatal("non-positive limit not reset to the default: ", err)
+	}
+	buffer.Release()
+
+	reader.SetMaxFrameLength(100)
+	go writer.WritePacket(buf.As(make([]byte, 1000)), M.Socksaddr{})
+	_, _, err = reader.ReadPacketThreadSafe()
+	var tooLarge *bufio.FrameTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Length != 1000 || tooLarge.MaxLength != 100 {
+		t.Fatal("frame over the limit accepted: ", err)
+	}
+}
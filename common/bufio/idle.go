@@ -0,0 +1,73 @@
+package bufio
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type idleTimer struct {
+	cancel   context.CancelFunc
+	timeout  int64
+	last     int64
+	timedOut int32
+	reset    chan struct{}
+}
+
+func newIdleTimer(ctx context.Context, cancel context.CancelFunc, timeout time.Duration) *idleTimer {
+	t := &idleTimer{
+		cancel:  cancel,
+		timeout: int64(timeout),
+		last:    time.Now().UnixNano(),
+		reset:   make(chan struct{}, 1),
+	}
+	go t.loop(ctx)
+	return t
+}
+
+// loop cancels after timeout passed since the last update, a timeout that is not positive waits for SetTimeout.
+func (t *idleTimer) loop(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		timeout := time.Duration(atomic.LoadInt64(&t.timeout))
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if timeout > 0 {
+			elapsed := time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&t.last))
+			if elapsed >= timeout {
+				atomic.StoreInt32(&t.timedOut, 1)
+				t.cancel()
+				return
+			}
+			timer.Reset(timeout - elapsed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.reset:
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *idleTimer) Update(n int64) {
+	atomic.StoreInt64(&t.last, time.Now().UnixNano())
+}
+
+func (t *idleTimer) SetTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&t.timeout, int64(timeout))
+	t.Update(0)
+	select {
+	case t.reset <- struct{}{}:
+	default:
+	}
+}
+
+func (t *idleTimer) TimedOut() bool {
+	return atomic.LoadInt32(&t.timedOut) != 0
+}
@@ -0,0 +1,297 @@
+package bufio
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/MehranF123/sing/common/buf"
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	udpSegment = 103
+	udpGRO     = 104
+
+	maxGSOSegments = 64
+	maxGSOSize     = 65000
+)
+
+var (
+	_ N.NetPacketConn     = (*OffloadUDPConn)(nil)
+	_ N.PacketBatchReader = (*OffloadUDPConn)(nil)
+	_ N.PacketBatchWriter = (*OffloadUDPConn)(nil)
+)
+
+// OffloadUDPConn reads with UDP_GRO and writes runs of packets with UDP_SEGMENT when the kernel supports them.
+//
+// The UDP conn is not exposed, since reading from it directly would return coalesced datagrams.
+// Reads copy each segment into the buffer of the caller, which keeps its reserved header.
+type OffloadUDPConn struct {
+	udpConn     ExtendedUDPConn
+	rawConn     syscall.RawConn
+	gro         bool
+	gsoDisabled int32
+	readAccess  sync.Mutex
+	oob         []byte
+	segments    *buf.Buffer
+	segmentSize int
+	source      M.Socksaddr
+}
+
+// NewOffloadPacketConn enables segmentation offload on conn, or returns a plain ExtendedUDPConn if it is not available.
+func NewOffloadPacketConn(conn *net.UDPConn) N.NetPacketConn {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return &ExtendedUDPConn{conn}
+	}
+	var groErr, gsoErr error
+	err = rawConn.Control(func(fd uintptr) {
+		groErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, udpGRO, 1)
+		_, gsoErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, udpSegment)
+	})
+	if err != nil || groErr != nil && gsoErr != nil {
+		return &ExtendedUDPConn{conn}
+	}
+	offloadConn := &OffloadUDPConn{
+		udpConn: ExtendedUDPConn{conn},
+		rawConn: rawConn,
+		gro:     groErr == nil,
+		oob:     make([]byte, unix.CmsgSpace(4)),
+	}
+	if gsoErr != nil {
+		offloadConn.gsoDisabled = 1
+	}
+	return offloadConn
+}
+
+func (c *OffloadUDPConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
+	c.readAccess.Lock()
+	defer c.readAccess.Unlock()
+	return c.readPacket(buffer.FreeBytes(), buffer)
+}
+
+func (c *OffloadUDPConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	c.readAccess.Lock()
+	defer c.readAccess.Unlock()
+	buffer := buf.With(p)
+	destination, err := c.readPacket(p, buffer)
+	if err != nil {
+		return
+	}
+	n = buffer.Len()
+	addr = destination.UDPAddr()
+	return
+}
+
+func (c *OffloadUDPConn) ReadPacketBatch(buffers []*buf.Buffer, destinations []M.Socksaddr) (n int, err error) {
+	c.readAccess.Lock()
+	defer c.readAccess.Unlock()
+	for n < len(buffers) {
+		if n > 0 && c.segments == nil {
+			return
+		}
+		var destination M.Socksaddr
+		destination, err = c.readPacket(buffers[n].FreeBytes(), buffers[n])
+		if err != nil {
+			return
+		}
+		destinations[n] = destination
+		n++
+	}
+	return
+}
+
+// readPacket copies the next segment to buffer, truncated to free like a datagram read. readAccess must be held.
+func (c *OffloadUDPConn) readPacket(free []byte, buffer *buf.Buffer) (destination M.Socksaddr, err error) {
+	if c.segments == nil {
+		err = c.readSegments()
+		if err != nil {
+			return
+		}
+	}
+	segmentSize := c.segmentSize
+	if segmentSize > c.segments.Len() {
+		segmentSize = c.segments.Len()
+	}
+	buffer.Truncate(buffer.Len() + copy(free, c.segments.To(segmentSize)))
+	c.segments.Advance(segmentSize)
+	if c.segments.IsEmpty() {
+		c.segments.Release()
+		c.segments = nil
+	}
+	return c.source, nil
+}
+
+func (c *OffloadUDPConn) readSegments() error {
+	buffer := buf.NewSize(65535)
+	var readN, oobN int
+	var from unix.Sockaddr
+	var innerErr error
+	err := c.rawConn.Read(func(fd uintptr) (done bool) {
+		readN, oobN, _, from, innerErr = unix.Recvmsg(int(fd), buffer.FreeBytes(), c.oob, 0)
+		return innerErr != unix.EAGAIN
+	})
+	if innerErr != nil {
+		err = innerErr
+	}
+	if err != nil {
+		buffer.Release()
+		return err
+	}
+	buffer.Truncate(readN)
+	c.segmentSize = readN
+	if c.gro && oobN > 0 {
+		messages, _ := unix.ParseSocketControlMessage(c.oob[:oobN])
+		for _, message := range messages {
+			// the segment size is a native int
+			if message.Header.Level == unix.IPPROTO_UDP && message.Header.Type == udpGRO && len(message.Data) >= 4 {
+				c.segmentSize = int(*(*int32)(unsafe.Pointer(&message.Data[0])))
+			}
+		}
+	}
+	if c.segmentSize == 0 {
+		c.segmentSize = readN
+	}
+	switch addr := from.(type) {
+	case *unix.SockaddrInet4:
+		c.source = M.SocksaddrFrom(netip.AddrFrom4(addr.Addr), uint16(addr.Port))
+	case *unix.SockaddrInet6:
+		c.source = M.SocksaddrFrom(netip.AddrFrom16(addr.Addr), uint16(addr.Port)).Unwrap()
+	default:
+		c.source = M.SocksaddrFromNet(c.udpConn.RemoteAddr())
+	}
+	c.segments = buffer
+	return nil
+}
+
+func (c *OffloadUDPConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	return c.udpConn.WritePacket(buffer, destination)
+}
+
+func (c *OffloadUDPConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	return c.udpConn.WriteTo(p, addr)
+}
+
+func (c *OffloadUDPConn) WriteVectorisedPacket(buffers []*buf.Buffer, destination M.Socksaddr) error {
+	return c.udpConn.WriteVectorisedPacket(buffers, destination)
+}
+
+// WritePacketBatch releases every buffer, the plain batch writes release their own part.
+func (c *OffloadUDPConn) WritePacketBatch(buffers []*buf.Buffer, destinations []M.Socksaddr) error {
+	if atomic.LoadInt32(&c.gsoDisabled) != 0 {
+		return c.udpConn.WritePacketBatch(buffers, destinations)
+	}
+	var plainStart int
+	for start := 0; start < len(buffers); {
+		end := gsoRunEnd(buffers, destinations, start)
+		if end-start < 2 {
+			start = end
+			continue
+		}
+		if plainStart < start {
+			err := c.udpConn.WritePacketBatch(buffers[plainStart:start], destinations[plainStart:start])
+			if err != nil {
+				buf.ReleaseMulti(buffers[start:])
+				return err
+			}
+		}
+		err := c.writeSegments(buffers[start:end], destinations[start])
+		if err != nil {
+			if !E.IsMulti(err, unix.EIO, unix.EINVAL, unix.ENOPROTOOPT, unix.EOPNOTSUPP) {
+				buf.ReleaseMulti(buffers[start:])
+				return err
+			}
+			// the kernel or the device refused the offload, send every packet on its own from now on
+			atomic.StoreInt32(&c.gsoDisabled, 1)
+			return c.udpConn.WritePacketBatch(buffers[start:], destinations[start:])
+		}
+		buf.ReleaseMulti(buffers[start:end])
+		start = end
+		plainStart = end
+	}
+	if plainStart < len(buffers) {
+		return c.udpConn.WritePacketBatch(buffers[plainStart:], destinations[plainStart:])
+	}
+	return nil
+}
+
+func gsoRunEnd(buffers []*buf.Buffer, destinations []M.Socksaddr, start int) int {
+	segmentSize := buffers[start].Len()
+	if segmentSize == 0 {
+		return start + 1
+	}
+	totalSize := segmentSize
+	end := start + 1
+	for ; end < len(buffers) && end-start < maxGSOSegments; end++ {
+		packetSize := buffers[end].Len()
+		// an empty packet can not be a segment, it would be dropped
+		if packetSize == 0 || destinations[end] != destinations[start] || packetSize > segmentSize || totalSize+packetSize > maxGSOSize {
+			break
+		}
+		totalSize += packetSize
+		if packetSize < segmentSize {
+			// only the last segment may be shorter
+			return end + 1
+		}
+	}
+	return end
+}
+
+func (c *OffloadUDPConn) writeSegments(buffers []*buf.Buffer, destination M.Socksaddr) error {
+	var sockaddr unix.Sockaddr
+	if c.udpConn.RemoteAddr() == nil {
+		if destination.IsFqdn() {
+			udpAddr, err := net.ResolveUDPAddr("udp", destination.String())
+			if err != nil {
+				return err
+			}
+			destination = M.SocksaddrFromNet(udpAddr)
+		}
+		sockaddr = toSockaddr(destination, M.AddrFromNetAddr(c.udpConn.LocalAddr()).Is4())
+	}
+	oob := make([]byte, unix.CmsgSpace(2))
+	header := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	header.Level = unix.IPPROTO_UDP
+	header.Type = udpSegment
+	header.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = uint16(buffers[0].Len())
+	data := buf.ToSliceMulti(buffers)
+	var innerErr error
+	err := c.rawConn.Write(func(fd uintptr) (done bool) {
+		_, innerErr = unix.SendmsgBuffers(int(fd), data, oob, sockaddr, 0)
+		return innerErr != unix.EAGAIN
+	})
+	if innerErr != nil {
+		err = innerErr
+	}
+	return err
+}
+
+func (c *OffloadUDPConn) LocalAddr() net.Addr {
+	return c.udpConn.LocalAddr()
+}
+
+func (c *OffloadUDPConn) SetDeadline(t time.Time) error {
+	return c.udpConn.SetDeadline(t)
+}
+
+func (c *OffloadUDPConn) SetReadDeadline(t time.Time) error {
+	return c.udpConn.SetReadDeadline(t)
+}
+
+func (c *OffloadUDPConn) SetWriteDeadline(t time.Time) error {
+	return c.udpConn.SetWriteDeadline(t)
+}
+
+func (c *OffloadUDPConn) Close() error {
+	return c.udpConn.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package bufio
+
+import (
+	"net"
+
+	N "github.com/MehranF123/sing/common/network"
+)
+
+func NewOffloadPacketConn(conn *net.UDPConn) N.NetPacketConn {
+	return &ExtendedUDPConn{conn}
+}
@@ -1,6 +1,7 @@
 package bufio
 
 import (
+	"context"
 	"io"
 
 	"github.com/MehranF123/sing/common"
@@ -21,13 +22,25 @@ func CopyOnce(dst io.Writer, src io.Reader) (n int64, err error) {
 }
 
 func CopyExtendedOnce(dst N.ExtendedWriter, src N.ExtendedReader) (n int64, err error) {
+	return CopyExtendedOnceContext(context.Background(), dst, src)
+}
+
+func CopyExtendedOnceContext(ctx context.Context, dst N.ExtendedWriter, src N.ExtendedReader) (n int64, err error) {
 	var buffer *buf.Buffer
 	if _, unsafe := common.Cast[N.ThreadUnsafeWriter](dst); unsafe {
-		buffer = buf.New()
+		buffer, err = buf.NewContext(ctx)
+		if err != nil {
+			return
+		}
 	} else {
-		_buffer := buf.StackNew()
+		var _buffer *buf.Buffer
+		_buffer, err = buf.StackNewContext(ctx)
+		if err != nil {
+			return
+		}
 		defer common.KeepAlive(_buffer)
 		buffer = common.Dup(_buffer)
+		defer buffer.Release()
 	}
 	err = src.ReadBuffer(buffer)
 	if err != nil {
@@ -0,0 +1,287 @@
+package bufio
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MehranF123/sing/common/buf"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+// Pipe creates a synchronous in-memory full duplex connection like net.Pipe.
+//
+// Buffers passed to WriteBuffer are handed to the other end without copying,
+// and ReadBufferThreadSafe returns them as they were written.
+func Pipe() (*PipeConn, *PipeConn) {
+	left := newPipeStream()
+	right := newPipeStream()
+	return newPipeConn(left, right), newPipeConn(right, left)
+}
+
+type pipeStream struct {
+	data      chan *buf.Buffer
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeStream() *pipeStream {
+	return &pipeStream{
+		data: make(chan *buf.Buffer),
+		done: make(chan struct{}),
+	}
+}
+
+func (s *pipeStream) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+var (
+	_ N.ExtendedConn     = (*PipeConn)(nil)
+	_ N.ThreadSafeReader = (*PipeConn)(nil)
+)
+
+type PipeConn struct {
+	reader        *pipeStream
+	writer        *pipeStream
+	pendingAccess sync.Mutex
+	pending       *buf.Buffer
+	readDeadline  pipeDeadline
+	writeDeadline pipeDeadline
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+func newPipeConn(reader *pipeStream, writer *pipeStream) *PipeConn {
+	return &PipeConn{
+		reader:        reader,
+		writer:        writer,
+		readDeadline:  makePipeDeadline(),
+		writeDeadline: makePipeDeadline(),
+		closed:        make(chan struct{}),
+	}
+}
+
+func (c *PipeConn) Read(p []byte) (n int, err error) {
+	buffer, err := c.next()
+	if err != nil {
+		return
+	}
+	n, _ = buffer.Read(p)
+	c.keep(buffer)
+	return
+}
+
+func (c *PipeConn) ReadBuffer(buffer *buf.Buffer) error {
+	next, err := c.next()
+	if err != nil {
+		return err
+	}
+	n := copy(buffer.FreeBytes(), next.Bytes())
+	buffer.Truncate(n)
+	next.Advance(n)
+	c.keep(next)
+	return nil
+}
+
+func (c *PipeConn) ReadBufferThreadSafe() (buffer *buf.Buffer, err error) {
+	return c.next()
+}
+
+func (c *PipeConn) next() (*buf.Buffer, error) {
+	select {
+	case <-c.closed:
+		return nil, io.ErrClosedPipe
+	case <-c.readDeadline.wait():
+		return nil, os.ErrDeadlineExceeded
+	default:
+	}
+	c.pendingAccess.Lock()
+	buffer := c.pending
+	c.pending = nil
+	c.pendingAccess.Unlock()
+	if buffer != nil {
+		return buffer, nil
+	}
+	select {
+	case buffer := <-c.reader.data:
+		return buffer, nil
+	case <-c.reader.done:
+		return nil, io.EOF
+	case <-c.closed:
+		return nil, io.ErrClosedPipe
+	case <-c.readDeadline.wait():
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// keep stores the unread rest of buffer for the next read, or releases it if the conn is closed.
+func (c *PipeConn) keep(buffer *buf.Buffer) {
+	if buffer.IsEmpty() {
+		buffer.Release()
+		return
+	}
+	c.pendingAccess.Lock()
+	defer c.pendingAccess.Unlock()
+	if isClosedChan(c.closed) {
+		buffer.Release()
+		return
+	}
+	c.pending = buffer
+}
+
+func (c *PipeConn) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		buffer := buf.New()
+		chunkLen, _ := buffer.Write(p)
+		err = c.WriteBuffer(buffer)
+		if err != nil {
+			return
+		}
+		n += chunkLen
+		p = p[chunkLen:]
+	}
+	return
+}
+
+func (c *PipeConn) WriteBuffer(buffer *buf.Buffer) error {
+	if buffer.IsEmpty() {
+		buffer.Release()
+		return nil
+	}
+	select {
+	case <-c.closed:
+		buffer.Release()
+		return io.ErrClosedPipe
+	case <-c.writer.done:
+		buffer.Release()
+		return io.ErrClosedPipe
+	case <-c.writeDeadline.wait():
+		buffer.Release()
+		return os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case c.writer.data <- buffer:
+		return nil
+	case <-c.closed:
+	case <-c.writer.done:
+	case <-c.writeDeadline.wait():
+		buffer.Release()
+		return os.ErrDeadlineExceeded
+	}
+	buffer.Release()
+	return io.ErrClosedPipe
+}
+
+// CloseWrite ends the stream read by the other end, which reads io.EOF after all written data.
+func (c *PipeConn) CloseWrite() error {
+	c.writer.close()
+	return nil
+}
+
+func (c *PipeConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.pendingAccess.Lock()
+		close(c.closed)
+		c.pending.Release()
+		c.pending = nil
+		c.pendingAccess.Unlock()
+		c.reader.close()
+		c.writer.close()
+	})
+	return nil
+}
+
+func (c *PipeConn) LocalAddr() net.Addr {
+	return pipeAddr{}
+}
+
+func (c *PipeConn) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
+
+func (c *PipeConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *PipeConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *PipeConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (pipeAddr) String() string {
+	return "pipe"
+}
+
+type pipeDeadline struct {
+	access sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makePipeDeadline() pipeDeadline {
+	return pipeDeadline{cancel: make(chan struct{})}
+}
+
+func (d *pipeDeadline) set(t time.Time) {
+	d.access.Lock()
+	defer d.access.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer already fired, wait for it to close the channel
+		<-d.cancel
+	}
+	d.timer = nil
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if duration := time.Until(t); duration > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(duration, func() {
+			close(cancel)
+		})
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *pipeDeadline) wait() chan struct{} {
+	d.access.Lock()
+	defer d.access.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
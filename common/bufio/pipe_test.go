@@ -0,0 +1,165 @@
+package bufio_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MehranF123/sing/common/buf"
+	"github.com/MehranF123/sing/common/bufio"
+)
+
+// countingAllocator counts the buffers taken from and returned to it.
+type countingAllocator struct {
+	access sync.Mutex
+	gets   int
+	puts   int
+}
+
+func (a *countingAllocator) Get(size int) []byte {
+	a.access.Lock()
+	a.gets++
+	a.access.Unlock()
+	return make([]byte, size)
+}
+
+func (a *countingAllocator) Put(buffer []byte) error {
+	a.access.Lock()
+	a.puts++
+	a.access.Unlock()
+	return nil
+}
+
+func (a *countingAllocator) outstanding() int {
+	a.access.Lock()
+	defer a.access.Unlock()
+	return a.gets - a.puts
+}
+
+func TestPipeReadWrite(t *testing.T) {
+	left, right := bufio.Pipe()
+	defer left.Close()
+	defer right.Close()
+	message := make([]byte, 3*buf.BufferSize+1)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	go func() {
+		_, err := left.Write(message)
+		if err != nil {
+			t.Error(err)
+		}
+		left.CloseWrite()
+	}()
+	// small reads leave the rest of each chunk pending
+	var received bytes.Buffer
+	_, err := io.CopyBuffer(&received, struct{ io.Reader }{right}, make([]byte, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received.Bytes(), message) {
+		t.Fatal("received ", received.Len(), " bytes, mismatched")
+	}
+}
+
+func TestPipeWriteHeader(t *testing.T) {
+	left, right := bufio.Pipe()
+	defer left.Close()
+	defer right.Close()
+	go left.Write([]byte("payload"))
+	buffer, err := right.ReadBufferThreadSafe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buffer.Release()
+	if buffer.Start() < buf.ReversedHeader {
+		t.Fatal("header room ", buffer.Start(), ", expected ", buf.ReversedHeader)
+	}
+	copy(buffer.ExtendHeader(2), "h:")
+	if string(buffer.Bytes()) != "h:payload" {
+		t.Fatal("unexpected content: ", string(buffer.Bytes()))
+	}
+}
+
+func TestPipeBufferPassThrough(t *testing.T) {
+	left, right := bufio.Pipe()
+	defer left.Close()
+	defer right.Close()
+	buffer := buf.New()
+	buffer.Write([]byte("zero copy"))
+	go left.WriteBuffer(buffer)
+	received, err := right.ReadBufferThreadSafe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer received.Release()
+	if received != buffer {
+		t.Fatal("buffer copied through the pipe")
+	}
+}
+
+func TestPipeCloseReleasesPending(t *testing.T) {
+	allocator := &countingAllocator{}
+	buf.SetAllocator(allocator)
+	defer buf.SetAllocator(nil)
+	left, right := bufio.Pipe()
+	go left.Write([]byte("unread data"))
+	_, err := io.ReadFull(right, make([]byte, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	right.Close()
+	left.Close()
+	if outstanding := allocator.outstanding(); outstanding != 0 {
+		t.Fatal("outstanding ", outstanding, " after close")
+	}
+	_, err = right.Read(make([]byte, 4))
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatal("read after close: ", err)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	left, right := bufio.Pipe()
+	right.Close()
+	_, err := left.Write([]byte("closed"))
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatal("write to closed peer: ", err)
+	}
+	_, err = left.Read(make([]byte, 1))
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("read from closed peer: ", err)
+	}
+	left.Close()
+}
+
+func TestPipeDeadline(t *testing.T) {
+	left, right := bufio.Pipe()
+	defer left.Close()
+	defer right.Close()
+	right.SetReadDeadline(time.Now().Add(-time.Second))
+	_, err := right.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("read after deadline: ", err)
+	}
+	left.SetWriteDeadline(time.Now().Add(-time.Second))
+	_, err = left.Write([]byte("late"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("write after deadline: ", err)
+	}
+	right.SetReadDeadline(time.Time{})
+	left.SetWriteDeadline(time.Time{})
+	go left.Write([]byte("on time"))
+	message := make([]byte, 7)
+	_, err = io.ReadFull(right, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message) != "on time" {
+		t.Fatal("unexpected content: ", string(message))
+	}
+}
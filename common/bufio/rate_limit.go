@@ -0,0 +1,301 @@
+package bufio
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MehranF123/sing/common"
+	"github.com/MehranF123/sing/common/buf"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+// RateLimiter is a token bucket in bytes, shared by all connections wrapped with it.
+//
+// A wait for more than the burst is admitted and paid back by later waits, so large buffers are never split.
+type RateLimiter struct {
+	access sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate bytes per second, burst defaults to rate if not positive.
+// A rate that is not positive means unlimited.
+func NewRateLimiter(rate int64, burst int64) *RateLimiter {
+	limiter := &RateLimiter{}
+	limiter.SetRate(rate, burst)
+	return limiter
+}
+
+// SetRate changes the rate and burst as NewRateLimiter, debt of more than one burst is forgiven.
+func (l *RateLimiter) SetRate(rate int64, burst int64) {
+	if rate < 0 {
+		rate = 0
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	l.access.Lock()
+	defer l.access.Unlock()
+	l.refill(time.Now())
+	if l.rate == 0 {
+		// start full like a new limiter
+		l.tokens = float64(burst)
+	}
+	l.rate = float64(rate)
+	l.burst = float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	} else if l.tokens < -l.burst {
+		l.tokens = -l.burst
+	}
+}
+
+// Wait takes n bytes from the bucket and blocks until the bucket is no longer in debt.
+// A nil or unlimited limiter never blocks.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.access.Lock()
+	if l.rate == 0 {
+		l.access.Unlock()
+		return nil
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.access.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+var (
+	_ N.ExtendedConn     = (*RateLimitedConn)(nil)
+	_ N.ThreadSafeReader = (*RateLimitedConn)(nil)
+)
+
+// RateLimitedConn waits on readLimiter after every read and on writeLimiter before every write.
+//
+// It is never replaced by its upstream during copy, and reads through it as a N.ThreadSafeReader,
+// so copy functions casting through Upstream do not skip the limiter.
+type RateLimitedConn struct {
+	N.ExtendedConn
+	ctx          context.Context
+	cancel       context.CancelFunc
+	readLimiter  *RateLimiter
+	writeLimiter *RateLimiter
+}
+
+// NewRateLimitedConn wraps conn, a nil limiter leaves its direction unlimited.
+func NewRateLimitedConn(conn net.Conn, readLimiter *RateLimiter, writeLimiter *RateLimiter) *RateLimitedConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RateLimitedConn{
+		ExtendedConn: NewExtendedConn(conn),
+		ctx:          ctx,
+		cancel:       cancel,
+		readLimiter:  readLimiter,
+		writeLimiter: writeLimiter,
+	}
+}
+
+func (c *RateLimitedConn) Read(p []byte) (n int, err error) {
+	n, err = c.ExtendedConn.Read(p)
+	if n > 0 {
+		waitErr := c.readLimiter.Wait(c.ctx, n)
+		if err == nil {
+			err = waitErr
+		}
+	}
+	return
+}
+
+func (c *RateLimitedConn) ReadBuffer(buffer *buf.Buffer) error {
+	err := c.ExtendedConn.ReadBuffer(buffer)
+	if err != nil {
+		return err
+	}
+	return c.readLimiter.Wait(c.ctx, buffer.Len())
+}
+
+func (c *RateLimitedConn) ReadBufferThreadSafe() (buffer *buf.Buffer, err error) {
+	if reader, isThreadSafeReader := common.Cast[N.ThreadSafeReader](c.ExtendedConn); isThreadSafeReader {
+		buffer, err = reader.ReadBufferThreadSafe()
+	} else {
+		buffer, err = buf.NewContext(c.ctx)
+		if err != nil {
+			return
+		}
+		readBufferRaw := buffer.Slice()
+		readBuffer := buf.With(readBufferRaw[:cap(readBufferRaw)-1024])
+		readBuffer.Reset()
+		err = c.ExtendedConn.ReadBuffer(readBuffer)
+		buffer.Resize(readBuffer.Start(), readBuffer.Len())
+	}
+	if err == nil {
+		err = c.readLimiter.Wait(c.ctx, buffer.Len())
+	}
+	if err != nil {
+		buffer.Release()
+		return nil, err
+	}
+	return
+}
+
+func (c *RateLimitedConn) Write(p []byte) (n int, err error) {
+	err = c.writeLimiter.Wait(c.ctx, len(p))
+	if err != nil {
+		return
+	}
+	return c.ExtendedConn.Write(p)
+}
+
+func (c *RateLimitedConn) WriteBuffer(buffer *buf.Buffer) error {
+	err := c.writeLimiter.Wait(c.ctx, buffer.Len())
+	if err != nil {
+		buffer.Release()
+		return err
+	}
+	return c.ExtendedConn.WriteBuffer(buffer)
+}
+
+func (c *RateLimitedConn) WriteVectorised(buffers []*buf.Buffer) error {
+	err := c.writeLimiter.Wait(c.ctx, buf.LenMulti(buffers))
+	if err != nil {
+		buf.ReleaseMulti(buffers)
+		return err
+	}
+	return NewVectorisedWriter(c.ExtendedConn).WriteVectorised(buffers)
+}
+
+func (c *RateLimitedConn) Close() error {
+	c.cancel()
+	return c.ExtendedConn.Close()
+}
+
+func (c *RateLimitedConn) Upstream() any {
+	return c.ExtendedConn
+}
+
+func (c *RateLimitedConn) ReaderReplaceable() bool {
+	return false
+}
+
+func (c *RateLimitedConn) WriterReplaceable() bool {
+	return false
+}
+
+var (
+	_ N.PacketConn             = (*RateLimitedPacketConn)(nil)
+	_ N.ThreadSafePacketReader = (*RateLimitedPacketConn)(nil)
+	_ N.VectorisedPacketWriter = (*RateLimitedPacketConn)(nil)
+)
+
+type RateLimitedPacketConn struct {
+	N.PacketConn
+	ctx          context.Context
+	cancel       context.CancelFunc
+	readLimiter  *RateLimiter
+	writeLimiter *RateLimiter
+}
+
+func NewRateLimitedPacketConn(conn N.PacketConn, readLimiter *RateLimiter, writeLimiter *RateLimiter) *RateLimitedPacketConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RateLimitedPacketConn{
+		PacketConn:   conn,
+		ctx:          ctx,
+		cancel:       cancel,
+		readLimiter:  readLimiter,
+		writeLimiter: writeLimiter,
+	}
+}
+
+func (c *RateLimitedPacketConn) ReadPacket(buffer *buf.Buffer) (destination M.Socksaddr, err error) {
+	destination, err = c.PacketConn.ReadPacket(buffer)
+	if err != nil {
+		return
+	}
+	err = c.readLimiter.Wait(c.ctx, buffer.Len())
+	return
+}
+
+func (c *RateLimitedPacketConn) ReadPacketThreadSafe() (buffer *buf.Buffer, destination M.Socksaddr, err error) {
+	if reader, isThreadSafeReader := common.Cast[N.ThreadSafePacketReader](c.PacketConn); isThreadSafeReader {
+		buffer, destination, err = reader.ReadPacketThreadSafe()
+	} else {
+		buffer, err = buf.NewPacketContext(c.ctx)
+		if err != nil {
+			return
+		}
+		destination, err = c.PacketConn.ReadPacket(buffer)
+	}
+	if err == nil {
+		err = c.readLimiter.Wait(c.ctx, buffer.Len())
+	}
+	if err != nil {
+		buffer.Release()
+		return nil, M.Socksaddr{}, err
+	}
+	return
+}
+
+func (c *RateLimitedPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	err := c.writeLimiter.Wait(c.ctx, buffer.Len())
+	if err != nil {
+		buffer.Release()
+		return err
+	}
+	return c.PacketConn.WritePacket(buffer, destination)
+}
+
+func (c *RateLimitedPacketConn) WriteVectorisedPacket(buffers []*buf.Buffer, destination M.Socksaddr) error {
+	err := c.writeLimiter.Wait(c.ctx, buf.LenMulti(buffers))
+	if err != nil {
+		buf.ReleaseMulti(buffers)
+		return err
+	}
+	return NewVectorisedPacketWriter(c.PacketConn).WriteVectorisedPacket(buffers, destination)
+}
+
+func (c *RateLimitedPacketConn) Close() error {
+	c.cancel()
+	return c.PacketConn.Close()
+}
+
+func (c *RateLimitedPacketConn) Upstream() any {
+	return c.PacketConn
+}
+
+func (c *RateLimitedPacketConn) ReaderReplaceable() bool {
+	return false
+}
+
+func (c *RateLimitedPacketConn) WriterReplaceable() bool {
+	return false
+}
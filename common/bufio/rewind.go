@@ -0,0 +1,152 @@
+package bufio
+
+import (
+	"net"
+
+	"github.com/MehranF123/sing/common/buf"
+	E "github.com/MehranF123/sing/common/exceptions"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+const (
+	// DefaultRewindSize is the default limit of data recorded by RewindConn.
+	DefaultRewindSize = buf.BufferSize
+	rewindInitialSize = 128
+)
+
+var (
+	ErrRewindOverflow = E.New("rewind buffer overflow")
+	ErrRewindStopped  = E.New("rewind after recording stopped")
+)
+
+var _ N.CachedReader = (*RewindConn)(nil)
+
+// RewindConn records everything read from conn until StopRecording is called,
+// so that a failed handshake can Rewind and hand the untouched stream to another handler.
+//
+// The record grows with the data read, up to the limit of the conn.
+type RewindConn struct {
+	net.Conn
+	buffer    *buf.Buffer
+	maxSize   int
+	recording bool
+	overflow  bool
+}
+
+func NewRewindConn(conn net.Conn) *RewindConn {
+	return NewRewindConnSize(conn, DefaultRewindSize)
+}
+
+// NewRewindConnSize creates a rewind conn recording up to maxSize bytes.
+func NewRewindConnSize(conn net.Conn, maxSize int) *RewindConn {
+	return &RewindConn{
+		Conn:      conn,
+		maxSize:   maxSize,
+		recording: true,
+	}
+}
+
+func (c *RewindConn) Read(p []byte) (n int, err error) {
+	if c.buffer != nil && !c.buffer.IsEmpty() {
+		n, err = c.buffer.Read(p)
+		if !c.recording && c.buffer.IsEmpty() {
+			c.buffer.Release()
+			c.buffer = nil
+		}
+		return
+	}
+	n, err = c.Conn.Read(p)
+	if n > 0 && c.recording {
+		if !c.grow(n) {
+			c.overflow = true
+			c.StopRecording()
+			return
+		}
+		c.buffer.Write(p[:n])
+		c.buffer.Advance(n)
+	}
+	return
+}
+
+// grow makes room to record n more bytes.
+func (c *RewindConn) grow(n int) bool {
+	var recorded int
+	if c.buffer != nil {
+		if c.buffer.FreeLen() >= n {
+			return true
+		}
+		recorded = c.buffer.Start() + c.buffer.Len()
+	}
+	if recorded+n > c.maxSize {
+		return false
+	}
+	size := rewindInitialSize
+	if c.buffer != nil {
+		size = 2 * c.buffer.Cap()
+	}
+	for size < recorded+n {
+		size *= 2
+	}
+	if size > c.maxSize {
+		size = c.maxSize
+	}
+	buffer := buf.NewSize(size)
+	if c.buffer != nil {
+		buffer.Write(c.buffer.Slice()[:recorded])
+		buffer.Advance(c.buffer.Start())
+		c.buffer.Release()
+	}
+	c.buffer = buffer
+	return true
+}
+
+// Rewind makes the following reads return all recorded data again.
+// It fails once recording has been stopped.
+func (c *RewindConn) Rewind() error {
+	if c.overflow {
+		return ErrRewindOverflow
+	}
+	if !c.recording {
+		return ErrRewindStopped
+	}
+	if c.buffer != nil {
+		c.buffer.Resize(0, c.buffer.Start()+c.buffer.Len())
+	}
+	return nil
+}
+
+// StopRecording drops data already read again and stops recording, pending data after a Rewind is still returned.
+func (c *RewindConn) StopRecording() {
+	c.recording = false
+	if c.buffer != nil && c.buffer.IsEmpty() {
+		c.buffer.Release()
+		c.buffer = nil
+	}
+}
+
+func (c *RewindConn) ReadCached() *buf.Buffer {
+	if c.buffer == nil || c.buffer.IsEmpty() {
+		return nil
+	}
+	buffer := c.buffer
+	c.buffer = nil
+	c.recording = false
+	return buffer
+}
+
+func (c *RewindConn) Upstream() any {
+	return c.Conn
+}
+
+func (c *RewindConn) ReaderReplaceable() bool {
+	return !c.recording && c.buffer == nil
+}
+
+func (c *RewindConn) WriterReplaceable() bool {
+	return true
+}
+
+func (c *RewindConn) Close() error {
+	c.buffer.Release()
+	return c.Conn.Close()
+}
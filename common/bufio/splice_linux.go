@@ -0,0 +1,141 @@
+package bufio
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/MehranF123/sing/common/buf"
+	E "github.com/MehranF123/sing/common/exceptions"
+	N "github.com/MehranF123/sing/common/network"
+
+	"golang.org/x/sys/unix"
+)
+
+const maxSpliceSize = 1 << 20
+
+// copyDirect splices between raw sockets for the cases the runtime does not cover:
+// (*net.TCPConn).ReadFrom only splices from a bare TCP conn, so it copies in user space
+// as soon as counters wrap the source, and older runtimes never splice into unix sockets.
+func copyDirect(dst io.Writer, src io.Reader, countFunc []N.CountFunc) (handled bool, n int64, err error) {
+	if len(countFunc) == 0 {
+		_, srcTCP := src.(*net.TCPConn)
+		_, dstTCP := dst.(*net.TCPConn)
+		if srcTCP && dstTCP {
+			return
+		}
+	}
+	srcConn, srcOk := spliceConn(src)
+	dstConn, dstOk := spliceConn(dst)
+	if !srcOk || !dstOk {
+		return
+	}
+	srcRawConn, err := srcConn.SyscallConn()
+	if err != nil {
+		return false, 0, nil
+	}
+	dstRawConn, err := dstConn.SyscallConn()
+	if err != nil {
+		return false, 0, nil
+	}
+	return splice(dst, dstRawConn, srcRawConn, countFunc)
+}
+
+func spliceConn(conn any) (syscall.Conn, bool) {
+	switch c := conn.(type) {
+	case *net.TCPConn:
+		return c, true
+	case *net.UnixConn:
+		if c.LocalAddr().Network() == "unix" {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func splice(dst io.Writer, dstRawConn syscall.RawConn, src syscall.RawConn, countFunc []N.CountFunc) (handled bool, n int64, err error) {
+	var pipes [2]int
+	err = unix.Pipe2(pipes[:], unix.O_CLOEXEC|unix.O_NONBLOCK)
+	if err != nil {
+		return false, 0, nil
+	}
+	defer unix.Close(pipes[0])
+	defer unix.Close(pipes[1])
+	for {
+		var readN int
+		var readErr error
+		err = src.Read(func(fd uintptr) (done bool) {
+			var spliceN int64
+			spliceN, readErr = spliceFd(int(fd), pipes[1], maxSpliceSize)
+			readN = int(spliceN)
+			return readErr != unix.EAGAIN
+		})
+		if err == nil {
+			err = readErr
+		}
+		if err != nil {
+			if n == 0 && E.IsMulti(err, unix.EINVAL, unix.ENOSYS, unix.EOPNOTSUPP) {
+				// splice not supported for this pair, let the caller fall back
+				return false, 0, nil
+			}
+			return true, n, err
+		}
+		if readN == 0 {
+			return true, n, nil
+		}
+		for readN > 0 {
+			var writeN int
+			var writeErr error
+			err = dstRawConn.Write(func(fd uintptr) (done bool) {
+				var spliceN int64
+				spliceN, writeErr = spliceFd(pipes[0], int(fd), readN)
+				writeN = int(spliceN)
+				return writeErr != unix.EAGAIN
+			})
+			if err == nil {
+				err = writeErr
+			}
+			if err == nil && writeN == 0 {
+				err = io.ErrShortWrite
+			}
+			if err != nil {
+				// the bytes left in the pipe were already consumed from src
+				drainN, drainErr := drainPipe(dst, pipes[0], readN)
+				n += drainN
+				countAll(countFunc, drainN)
+				if drainErr != nil {
+					return true, n, drainErr
+				}
+				return true, n, err
+			}
+			readN -= writeN
+			n += int64(writeN)
+			countAll(countFunc, int64(writeN))
+		}
+	}
+}
+
+// drainPipe writes the size bytes left in the pipe to dst with plain writes.
+func drainPipe(dst io.Writer, pipe int, size int) (n int64, err error) {
+	buffer := buf.NewSize(size)
+	defer buffer.Release()
+	for buffer.Len() < size {
+		var readN int
+		readN, err = unix.Read(pipe, buffer.FreeBytes()[:size-buffer.Len()])
+		if err != nil {
+			return 0, os.NewSyscallError("read", err)
+		}
+		if readN == 0 {
+			break
+		}
+		buffer.Truncate(buffer.Len() + readN)
+	}
+	writeN, err := dst.Write(buffer.Bytes())
+	return int64(writeN), err
+}
+
+func spliceFd(rfd int, wfd int, size int) (int64, error) {
+	n, err := unix.Splice(rfd, nil, wfd, nil, size, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+	return int64(n), err
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package bufio
+
+import (
+	"io"
+
+	N "github.com/MehranF123/sing/common/network"
+)
+
+func copyDirect(dst io.Writer, src io.Reader, countFunc []N.CountFunc) (handled bool, n int64, err error) {
+	return
+}
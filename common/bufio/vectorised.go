@@ -0,0 +1,130 @@
+package bufio
+
+import (
+	"io"
+	"net"
+
+	"github.com/MehranF123/sing/common"
+	"github.com/MehranF123/sing/common/buf"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+func NewVectorisedWriter(writer io.Writer) N.VectorisedWriter {
+	if vectorisedWriter, ok := CreateVectorisedWriter(N.UnwrapWriter(writer)); ok {
+		return vectorisedWriter
+	}
+	return &BufferedVectorisedWriter{upstream: writer}
+}
+
+func CreateVectorisedWriter(writer any) (N.VectorisedWriter, bool) {
+	switch w := writer.(type) {
+	case N.VectorisedWriter:
+		return w, true
+	case *net.TCPConn:
+		return &NetVectorisedWriterWrapper{w}, true
+	case *net.UDPConn:
+		return &NetVectorisedWriterWrapper{w}, true
+	case *net.IPConn:
+		return &NetVectorisedWriterWrapper{w}, true
+	case *net.UnixConn:
+		return &NetVectorisedWriterWrapper{w}, true
+	}
+	return nil, false
+}
+
+func NewVectorisedPacketWriter(writer N.PacketWriter) N.VectorisedPacketWriter {
+	if vectorisedWriter, ok := common.Cast[N.VectorisedPacketWriter](writer); ok {
+		return vectorisedWriter
+	}
+	return &BufferedVectorisedPacketWriter{upstream: writer}
+}
+
+func WriteVectorised(writer N.VectorisedWriter, data [][]byte) (n int, err error) {
+	buffers := make([]*buf.Buffer, 0, len(data))
+	for _, p := range data {
+		buffers = append(buffers, buf.As(p))
+	}
+	err = writer.WriteVectorised(buffers)
+	if err == nil {
+		for _, p := range data {
+			n += len(p)
+		}
+	}
+	return
+}
+
+func WriteVectorisedPacket(writer N.VectorisedPacketWriter, data [][]byte, destination M.Socksaddr) (n int, err error) {
+	buffers := make([]*buf.Buffer, 0, len(data))
+	for _, p := range data {
+		buffers = append(buffers, buf.As(p))
+	}
+	err = writer.WriteVectorisedPacket(buffers, destination)
+	if err == nil {
+		for _, p := range data {
+			n += len(p)
+		}
+	}
+	return
+}
+
+var _ N.VectorisedWriter = (*BufferedVectorisedWriter)(nil)
+
+type BufferedVectorisedWriter struct {
+	upstream io.Writer
+}
+
+func (w *BufferedVectorisedWriter) WriteVectorised(buffers []*buf.Buffer) error {
+	defer buf.ReleaseMulti(buffers)
+	if len(buffers) == 1 {
+		return common.Error(w.upstream.Write(buffers[0].Bytes()))
+	}
+	bufferLen := buf.LenMulti(buffers)
+	if bufferLen == 0 {
+		return nil
+	}
+	buffer := buf.NewSize(bufferLen)
+	defer buffer.Release()
+	buf.CopyMulti(buffer.Extend(bufferLen), buffers)
+	return common.Error(w.upstream.Write(buffer.Bytes()))
+}
+
+func (w *BufferedVectorisedWriter) Upstream() any {
+	return w.upstream
+}
+
+var _ N.VectorisedWriter = (*NetVectorisedWriterWrapper)(nil)
+
+// NetVectorisedWriterWrapper writes with net.Buffers, which turns into a single writev call on net conns.
+type NetVectorisedWriterWrapper struct {
+	upstream io.Writer
+}
+
+func (w *NetVectorisedWriterWrapper) WriteVectorised(buffers []*buf.Buffer) error {
+	defer buf.ReleaseMulti(buffers)
+	netBuffers := net.Buffers(buf.ToSliceMulti(buffers))
+	return common.Error(netBuffers.WriteTo(w.upstream))
+}
+
+func (w *NetVectorisedWriterWrapper) Upstream() any {
+	return w.upstream
+}
+
+var _ N.VectorisedPacketWriter = (*BufferedVectorisedPacketWriter)(nil)
+
+type BufferedVectorisedPacketWriter struct {
+	upstream N.PacketWriter
+}
+
+func (w *BufferedVectorisedPacketWriter) WriteVectorisedPacket(buffers []*buf.Buffer, destination M.Socksaddr) error {
+	defer buf.ReleaseMulti(buffers)
+	bufferLen := buf.LenMulti(buffers)
+	buffer := buf.NewSize(buf.ReversedHeader + bufferLen)
+	buffer.Resize(buf.ReversedHeader, 0)
+	buf.CopyMulti(buffer.Extend(bufferLen), buffers)
+	return w.upstream.WritePacket(buffer, destination)
+}
+
+func (w *BufferedVectorisedPacketWriter) Upstream() any {
+	return w.upstream
+}
@@ -0,0 +1,56 @@
+//go:build !windows
+
+package bufio
+
+import (
+	"net"
+
+	"github.com/MehranF123/sing/common/buf"
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+
+	"golang.org/x/sys/unix"
+)
+
+func (w *ExtendedUDPConn) WriteVectorisedPacket(buffers []*buf.Buffer, destination M.Socksaddr) error {
+	defer buf.ReleaseMulti(buffers)
+	var sockaddr unix.Sockaddr
+	if w.RemoteAddr() == nil {
+		if destination.IsFqdn() {
+			udpAddr, err := net.ResolveUDPAddr("udp", destination.String())
+			if err != nil {
+				return err
+			}
+			destination = M.SocksaddrFromNet(udpAddr)
+		}
+		sockaddr = toSockaddr(destination, M.AddrFromNetAddr(w.LocalAddr()).Is4())
+	}
+	rawConn, err := w.SyscallConn()
+	if err != nil {
+		return err
+	}
+	data := buf.ToSliceMulti(buffers)
+	var innerErr error
+	err = rawConn.Write(func(fd uintptr) (done bool) {
+		_, innerErr = unix.SendmsgBuffers(int(fd), data, nil, sockaddr, 0)
+		if innerErr == unix.EAGAIN {
+			innerErr = nil
+			return false
+		}
+		return true
+	})
+	return E.Errors(innerErr, err)
+}
+
+func toSockaddr(destination M.Socksaddr, inet4 bool) unix.Sockaddr {
+	if inet4 && destination.IsIPv4() {
+		return &unix.SockaddrInet4{
+			Port: int(destination.Port),
+			Addr: destination.Addr.As4(),
+		}
+	}
+	return &unix.SockaddrInet6{
+		Port: int(destination.Port),
+		Addr: destination.Addr.As16(),
+	}
+}
@@ -0,0 +1,18 @@
+package bufio
+
+import (
+	"github.com/MehranF123/sing/common/buf"
+	M "github.com/MehranF123/sing/common/metadata"
+)
+
+func (w *ExtendedUDPConn) WriteVectorisedPacket(buffers []*buf.Buffer, destination M.Socksaddr) error {
+	bufferLen := buf.LenMulti(buffers)
+	if bufferLen == 0 {
+		buf.ReleaseMulti(buffers)
+		return w.WritePacket(buf.As(nil), destination)
+	}
+	buffer := buf.NewSize(bufferLen)
+	buf.CopyMulti(buffer.Extend(bufferLen), buffers)
+	buf.ReleaseMulti(buffers)
+	return w.WritePacket(buffer, destination)
+}
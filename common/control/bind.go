@@ -2,6 +2,7 @@ package control
 
 import (
 	"net"
+	"sync"
 
 	E "github.com/MehranF123/sing/common/exceptions"
 )
@@ -12,23 +13,31 @@ type BindManager interface {
 }
 
 type myBindManager struct {
+	access               sync.RWMutex
 	interfaceIndexByName map[string]int
 }
 
 func (m *myBindManager) IndexByName(name string) (int, error) {
-	if index, loaded := m.interfaceIndexByName[name]; loaded {
+	if index, loaded := m.loadIndex(name); loaded {
 		return index, nil
 	}
 	err := m.Update()
 	if err != nil {
 		return 0, err
 	}
-	if index, loaded := m.interfaceIndexByName[name]; loaded {
+	if index, loaded := m.loadIndex(name); loaded {
 		return index, nil
 	}
 	return 0, E.New("interface ", name, " not found")
 }
 
+func (m *myBindManager) loadIndex(name string) (int, bool) {
+	m.access.RLock()
+	defer m.access.RUnlock()
+	index, loaded := m.interfaceIndexByName[name]
+	return index, loaded
+}
+
 func (m *myBindManager) Update() error {
 	interfaces, err := net.Interfaces()
 	if err != nil {
@@ -38,6 +47,8 @@ func (m *myBindManager) Update() error {
 	for _, iface := range interfaces {
 		interfaceIndexByName[iface.Name] = iface.Index
 	}
+	m.access.Lock()
 	m.interfaceIndexByName = interfaceIndexByName
+	m.access.Unlock()
 	return nil
 }
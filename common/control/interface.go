@@ -1,6 +1,7 @@
 package control
 
 import (
+	"strings"
 	"syscall"
 
 	E "github.com/MehranF123/sing/common/exceptions"
@@ -29,3 +30,14 @@ func Control(conn syscall.RawConn, block func(fd uintptr) error) error {
 	})
 	return E.Errors(innerErr, err)
 }
+
+func isIPv6(network string) bool {
+	return strings.HasSuffix(network, "6")
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
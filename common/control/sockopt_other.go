@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package control
+
+func TrafficClass(class int) Func {
+	return nil
+}
+
+func IPv6Only(enabled bool) Func {
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package control
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// TrafficClass sets the IPv4 TOS or the IPv6 traffic class byte, DSCP is the upper six bits.
+func TrafficClass(class int) Func {
+	return func(network, address string, conn syscall.RawConn) error {
+		return Control(conn, func(fd uintptr) error {
+			if !isIPv6(network) {
+				return unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, class)
+			}
+			// dual stack sockets also send IPv4 packets, which use IP_TOS if the system supports it
+			unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, class)
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, class)
+		})
+	}
+}
+
+// IPv6Only sets IPV6_V6ONLY on IPv6 sockets, overriding the default of the net package.
+func IPv6Only(enabled bool) Func {
+	return func(network, address string, conn syscall.RawConn) error {
+		if !isIPv6(network) {
+			return nil
+		}
+		return Control(conn, func(fd uintptr) error {
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, boolToInt(enabled))
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package control
+
+import (
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// TCPUserTimeout sets how long transmitted data may stay unacknowledged before the connection is closed.
+func TCPUserTimeout(timeout time.Duration) Func {
+	return func(network, address string, conn syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		return Control(conn, func(fd uintptr) error {
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(timeout.Milliseconds()))
+		})
+	}
+}
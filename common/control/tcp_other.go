@@ -0,0 +1,9 @@
+//go:build !linux
+
+package control
+
+import "time"
+
+func TCPUserTimeout(timeout time.Duration) Func {
+	return nil
+}
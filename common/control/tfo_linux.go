@@ -0,0 +1,33 @@
+package control
+
+import (
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// TCPFastOpen sends the data of the first write in the SYN if the server cookie is cached,
+// so it only fits protocols where the client writes first.
+func TCPFastOpen() Func {
+	return func(network, address string, conn syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		return Control(conn, func(fd uintptr) error {
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+		})
+	}
+}
+
+// TCPFastOpenListener accepts data in the SYN with a queue of queueLength pending requests.
+func TCPFastOpenListener(queueLength int) Func {
+	return func(network, address string, conn syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		return Control(conn, func(fd uintptr) error {
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, queueLength)
+		})
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package control
+
+func TCPFastOpen() Func {
+	return nil
+}
+
+func TCPFastOpenListener(queueLength int) Func {
+	return nil
+}
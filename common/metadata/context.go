@@ -0,0 +1,16 @@
+package metadata
+
+import "context"
+
+type metadataKey struct{}
+
+// ContextWithMetadata returns ctx carrying metadata, which the socks, http and trojan servers set
+// for the contexts passed to their handlers.
+func ContextWithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, (*metadataKey)(nil), metadata)
+}
+
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	metadata, loaded := ctx.Value((*metadataKey)(nil)).(Metadata)
+	return metadata, loaded
+}
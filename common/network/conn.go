@@ -24,6 +24,14 @@ type PacketWriter interface {
 	WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error
 }
 
+type PacketBatchReader interface {
+	ReadPacketBatch(buffers []*buf.Buffer, destinations []M.Socksaddr) (n int, err error)
+}
+
+type PacketBatchWriter interface {
+	WritePacketBatch(buffers []*buf.Buffer, destinations []M.Socksaddr) error
+}
+
 type PacketConn interface {
 	PacketReader
 	PacketWriter
@@ -45,6 +53,14 @@ type ExtendedWriter interface {
 	WriteBuffer(buffer *buf.Buffer) error
 }
 
+type VectorisedWriter interface {
+	WriteVectorised(buffers []*buf.Buffer) error
+}
+
+type VectorisedPacketWriter interface {
+	WriteVectorisedPacket(buffers []*buf.Buffer, destination M.Socksaddr) error
+}
+
 type ExtendedConn interface {
 	ExtendedReader
 	ExtendedWriter
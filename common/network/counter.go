@@ -0,0 +1,30 @@
+package network
+
+import (
+	"io"
+)
+
+type CountFunc func(n int64)
+
+// ReadCounter is a reader that counts what is read from its upstream.
+// The copy functions of bufio unwrap it and keep counting on the fast path of the upstream,
+// UnwrapReader stops at it, since it is not replaceable.
+type ReadCounter interface {
+	io.Reader
+	UnwrapReader() (io.Reader, []CountFunc)
+}
+
+type WriteCounter interface {
+	io.Writer
+	UnwrapWriter() (io.Writer, []CountFunc)
+}
+
+type PacketReadCounter interface {
+	PacketReader
+	UnwrapPacketReader() (PacketReader, []CountFunc)
+}
+
+type PacketWriteCounter interface {
+	PacketWriter
+	UnwrapPacketWriter() (PacketWriter, []CountFunc)
+}
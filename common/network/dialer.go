@@ -2,8 +2,17 @@ package network
 
 import (
 	"context"
+	"io"
 	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/MehranF123/sing/common"
+	"github.com/MehranF123/sing/common/buf"
+	"github.com/MehranF123/sing/common/control"
+	E "github.com/MehranF123/sing/common/exceptions"
 	M "github.com/MehranF123/sing/common/metadata"
 )
 
@@ -14,15 +23,349 @@ type Dialer interface {
 
 var SystemDialer Dialer = &DefaultDialer{}
 
+var (
+	ErrTCPFastOpenUnavailable  = E.New("tcp fast open unavailable")
+	ErrMultipathTCPUnavailable = E.New("multipath tcp unavailable")
+)
+
+type DialerOptions struct {
+	// BindAddress4 and BindAddress6 are the local addresses of sockets to IPv4 and IPv6 destinations.
+	BindAddress4  netip.Addr
+	BindAddress6  netip.Addr
+	BindInterface string
+	// BindManager resolves BindInterface on systems binding by index, a new one is created if nil.
+	BindManager control.BindManager
+	// InterfaceMonitor binds sockets to its current default interface if BindInterface is empty.
+	// NewDefaultDialer updates BindManager on its events until the dialer is closed.
+	InterfaceMonitor InterfaceMonitor
+	RoutingMark      int
+	// ProtectPath is the Android VPN service socket that protects sockets from the tunnel.
+	ProtectPath string
+	ReuseAddr   bool
+	// ConnectTimeout is the default timeout of DialContext.
+	ConnectTimeout time.Duration
+	// TCPKeepAlive is the TCP keepalive interval, the net package default if zero, disabled if negative.
+	TCPKeepAlive      time.Duration
+	DisableTCPNoDelay bool
+	TCPUserTimeout    time.Duration
+	// TrafficClass is the IPv4 TOS or IPv6 traffic class byte, set if not zero.
+	TrafficClass int
+	// IPv6Only sets IPV6_V6ONLY on IPv6 sockets, which disables dual stack listeners.
+	IPv6Only bool
+	// TCPFastOpen sends the first write of connections in the SYN, see control.TCPFastOpen.
+	TCPFastOpen bool
+	// TCPFastOpenQueueLength enables TCP Fast Open on listeners with the pending request queue length if positive.
+	TCPFastOpenQueueLength int
+	// MultipathTCP opens TCP sockets as IPPROTO_MPTCP, only supported on Linux.
+	MultipathTCP bool
+	// OnFallback is called when TCP Fast Open or Multipath TCP is unavailable and plain TCP is used instead,
+	// the error wraps ErrTCPFastOpenUnavailable or ErrMultipathTCPUnavailable.
+	OnFallback func(err error)
+}
+
+// Control returns the control.Func chain applying the socket options.
+func (o DialerOptions) Control() control.Func {
+	var controlFunc control.Func
+	if o.BindInterface != "" || o.InterfaceMonitor != nil {
+		bindManager := o.BindManager
+		if bindManager == nil {
+			bindManager = control.NewBindManager()
+		}
+		if o.BindInterface != "" {
+			controlFunc = control.Append(controlFunc, control.BindToInterface(bindManager, o.BindInterface))
+		} else {
+			controlFunc = control.Append(controlFunc, control.BindToInterfaceFunc(bindManager, o.InterfaceMonitor.DefaultInterfaceName))
+		}
+	}
+	if o.RoutingMark != 0 {
+		controlFunc = control.Append(controlFunc, control.RoutingMark(o.RoutingMark))
+	}
+	if o.ProtectPath != "" {
+		controlFunc = control.Append(controlFunc, control.ProtectPath(o.ProtectPath))
+	}
+	if o.ReuseAddr {
+		controlFunc = control.Append(controlFunc, control.ReuseAddr())
+	}
+	if o.TCPUserTimeout > 0 {
+		controlFunc = control.Append(controlFunc, control.TCPUserTimeout(o.TCPUserTimeout))
+	}
+	if o.TrafficClass != 0 {
+		controlFunc = control.Append(controlFunc, control.TrafficClass(o.TrafficClass))
+	}
+	if o.IPv6Only {
+		controlFunc = control.Append(controlFunc, control.IPv6Only(true))
+	}
+	return controlFunc
+}
+
 type DefaultDialer struct {
 	net.Dialer
 	net.ListenConfig
+	bindAddress4      netip.Addr
+	bindAddress6      netip.Addr
+	disableTCPNoDelay bool
+	multipathTCP      bool
+	onFallback        func(err error)
+	bindUpdater       io.Closer
+}
+
+// NewDefaultDialer creates a system dialer applying options to all sockets it creates.
+//
+// Keepalive and TCP_NODELAY are set by the net package after connecting,
+// so they are applied to the dialer and the connection instead of the control chain.
+// It fails if the InterfaceMonitor of options is closed.
+func NewDefaultDialer(options DialerOptions) (*DefaultDialer, error) {
+	var bindUpdater io.Closer
+	if options.InterfaceMonitor != nil {
+		if options.BindManager == nil {
+			options.BindManager = control.NewBindManager()
+		}
+		var err error
+		bindUpdater, err = AutoUpdateBindManager(options.InterfaceMonitor, options.BindManager)
+		if err != nil {
+			return nil, E.Cause(err, "subscribe interface monitor")
+		}
+	}
+	dialControl := options.Control()
+	listenControl := dialControl
+	if options.TCPFastOpen {
+		dialControl = control.Append(dialControl, fallbackControl(ErrTCPFastOpenUnavailable, control.TCPFastOpen(), options.OnFallback))
+	}
+	if options.TCPFastOpenQueueLength > 0 {
+		listenControl = control.Append(listenControl, fallbackControl(ErrTCPFastOpenUnavailable, control.TCPFastOpenListener(options.TCPFastOpenQueueLength), options.OnFallback))
+	}
+	return &DefaultDialer{
+		Dialer: net.Dialer{
+			Timeout:   options.ConnectTimeout,
+			KeepAlive: options.TCPKeepAlive,
+			Control:   dialControl,
+		},
+		ListenConfig: net.ListenConfig{
+			KeepAlive: options.TCPKeepAlive,
+			Control:   listenControl,
+		},
+		bindAddress4:      options.BindAddress4,
+		bindAddress6:      options.BindAddress6,
+		disableTCPNoDelay: options.DisableTCPNoDelay,
+		multipathTCP:      options.MultipathTCP,
+		onFallback:        options.OnFallback,
+		bindUpdater:       bindUpdater,
+	}, nil
+}
+
+// fallbackControl applies an optional TCP option, a failure is reported to onFallback and leaves a plain TCP socket.
+func fallbackControl(unavailableErr error, controlFunc control.Func, onFallback func(err error)) control.Func {
+	return func(network, address string, conn syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		var err error
+		if controlFunc == nil {
+			err = E.Extend(unavailableErr, "unsupported platform")
+		} else if err = controlFunc(network, address, conn); err != nil {
+			err = E.Extend(unavailableErr, err.Error())
+		} else {
+			return nil
+		}
+		if onFallback != nil {
+			onFallback(err)
+		}
+		return nil
+	}
 }
 
 func (d *DefaultDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
-	return d.Dialer.DialContext(ctx, network, destination.String())
+	var (
+		conn net.Conn
+		err  error
+	)
+	if d.multipathTCP && strings.HasPrefix(network, "tcp") {
+		conn, err = d.dialMultipathTCP(ctx, network, destination)
+		if err != nil && E.IsMulti(err, ErrMultipathTCPUnavailable) {
+			d.fallback(err)
+			conn, err = nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if conn == nil {
+		conn, err = d.dialer(network, destination).DialContext(ctx, network, destination.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.disableTCPNoDelay {
+		if tcpConn, isTCPConn := conn.(*net.TCPConn); isTCPConn {
+			err = tcpConn.SetNoDelay(false)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+	return conn, nil
 }
 
+func (d *DefaultDialer) dialMultipathTCP(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if d.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	if !d.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, d.Deadline)
+		defer cancel()
+	}
+	if destination.IsIP() {
+		return dialMultipathTCP(ctx, d.dialer(network, destination), destination)
+	}
+	// resolve and race the addresses like any other dialer, only the sockets are Multipath TCP
+	return NewResolveDialer(multipathTCPDialer{d}, SystemResolver, DomainStrategyAsIS, 0).DialContext(ctx, network, destination)
+}
+
+// multipathTCPDialer connects resolved addresses with Multipath TCP sockets of the DefaultDialer.
+type multipathTCPDialer struct {
+	*DefaultDialer
+}
+
+func (d multipathTCPDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	return dialMultipathTCP(ctx, d.dialer(network, destination), destination)
+}
+
+// Listen listens for TCP connections with the listener options, as Multipath TCP if enabled.
+func (d *DefaultDialer) Listen(ctx context.Context, network string, address string) (net.Listener, error) {
+	if d.multipathTCP && strings.HasPrefix(network, "tcp") {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, err
+		}
+		listenAddress := M.ParseSocksaddr(address)
+		if !listenAddress.IsValid() {
+			if network == "tcp4" {
+				listenAddress.Addr = netip.IPv4Unspecified()
+			} else {
+				listenAddress.Addr = netip.IPv6Unspecified()
+			}
+		}
+		if listenAddress.IsIP() {
+			listener, err := listenMultipathTCP(&d.ListenConfig, listenAddress.Unwrap().AddrPort())
+			if err == nil || !E.IsMulti(err, ErrMultipathTCPUnavailable) {
+				return listener, err
+			}
+			d.fallback(err)
+		} else {
+			d.fallback(E.Extend(ErrMultipathTCPUnavailable, "listen on domain address"))
+		}
+	}
+	return d.ListenConfig.Listen(ctx, network, address)
+}
+
+// Close stops updating the BindManager on interface monitor events.
+func (d *DefaultDialer) Close() error {
+	return common.Close(d.bindUpdater)
+}
+
+func (d *DefaultDialer) fallback(err error) {
+	if d.onFallback != nil {
+		d.onFallback(err)
+	}
+}
+
+// ListenPacket listens on udp4 or udp6 by the family of destination, or of the only configured bind address.
+// A dual stack socket is only created when neither decides the family.
 func (d *DefaultDialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
-	return d.ListenConfig.ListenPacket(ctx, "udp", "")
+	network, bindAddress := d.listenAddress(destination)
+	var address string
+	if bindAddress.IsValid() {
+		address = M.SocksaddrFrom(bindAddress, 0).String()
+	}
+	return d.ListenConfig.ListenPacket(ctx, network, address)
+}
+
+// DialPacket creates a UDP socket connected to destination, which only exchanges packets with it.
+func (d *DefaultDialer) DialPacket(ctx context.Context, destination M.Socksaddr) (BindPacketConn, error) {
+	network, _ := d.listenAddress(destination)
+	conn, err := d.dialer(network, destination).DialContext(ctx, network, destination.String())
+	if err != nil {
+		return nil, err
+	}
+	udpConn, isUDPConn := conn.(*net.UDPConn)
+	if !isUDPConn {
+		conn.Close()
+		return nil, E.New("dial packet: not a udp conn: ", conn.LocalAddr())
+	}
+	return &connectedPacketConn{udpConn}, nil
+}
+
+func (d *DefaultDialer) listenAddress(destination M.Socksaddr) (string, netip.Addr) {
+	switch {
+	case destination.IsIPv4():
+		return "udp4", d.bindAddress4
+	case destination.IsIPv6():
+		return "udp6", d.bindAddress6
+	case d.bindAddress4.IsValid() && !d.bindAddress6.IsValid():
+		return "udp4", d.bindAddress4
+	case d.bindAddress6.IsValid() && !d.bindAddress4.IsValid():
+		return "udp6", d.bindAddress6
+	default:
+		return "udp", netip.Addr{}
+	}
+}
+
+func (d *DefaultDialer) dialer(network string, destination M.Socksaddr) *net.Dialer {
+	var bindAddress netip.Addr
+	switch {
+	case destination.IsIPv4() || strings.HasSuffix(network, "4"):
+		bindAddress = d.bindAddress4
+	case destination.IsIPv6() || strings.HasSuffix(network, "6"):
+		bindAddress = d.bindAddress6
+	}
+	if !bindAddress.IsValid() {
+		return &d.Dialer
+	}
+	dialer := d.Dialer
+	bindAddr := M.SocksaddrFrom(bindAddress, 0)
+	if strings.HasPrefix(network, "udp") {
+		dialer.LocalAddr = bindAddr.UDPAddr()
+	} else {
+		dialer.LocalAddr = bindAddr.TCPAddr()
+	}
+	return &dialer
+}
+
+var _ BindPacketConn = (*connectedPacketConn)(nil)
+
+type connectedPacketConn struct {
+	*net.UDPConn
+}
+
+func (c *connectedPacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	n, err = c.UDPConn.Read(p)
+	if err == nil {
+		addr = c.UDPConn.RemoteAddr()
+	}
+	return
+}
+
+func (c *connectedPacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	return c.UDPConn.Write(p)
+}
+
+func (c *connectedPacketConn) ReadPacket(buffer *buf.Buffer) (destination M.Socksaddr, err error) {
+	n, err := c.UDPConn.Read(buffer.FreeBytes())
+	if err != nil {
+		return
+	}
+	buffer.Truncate(n)
+	return M.SocksaddrFromNet(c.UDPConn.RemoteAddr()), nil
+}
+
+func (c *connectedPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	defer buffer.Release()
+	_, err := c.UDPConn.Write(buffer.Bytes())
+	return err
+}
+
+func (c *connectedPacketConn) Upstream() any {
+	return c.UDPConn
 }
@@ -0,0 +1,295 @@
+package network
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MehranF123/sing/common/cache"
+	E "github.com/MehranF123/sing/common/exceptions"
+	F "github.com/MehranF123/sing/common/format"
+	M "github.com/MehranF123/sing/common/metadata"
+)
+
+type BalanceStrategy uint8
+
+const (
+	BalanceRoundRobin BalanceStrategy = iota
+	BalanceLeastActive
+	BalanceHashDestination
+	BalanceHashSource
+)
+
+func (s BalanceStrategy) String() string {
+	switch s {
+	case BalanceRoundRobin:
+		return "round_robin"
+	case BalanceLeastActive:
+		return "least_active"
+	case BalanceHashDestination:
+		return "hash_destination"
+	case BalanceHashSource:
+		return "hash_source"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseBalanceStrategy(strategy string) (BalanceStrategy, error) {
+	switch strategy {
+	case "", "round_robin":
+		return BalanceRoundRobin, nil
+	case "least_active":
+		return BalanceLeastActive, nil
+	case "hash_destination":
+		return BalanceHashDestination, nil
+	case "hash_source":
+		return BalanceHashSource, nil
+	}
+	return 0, E.New("unknown balance strategy: ", strategy)
+}
+
+// hashReplicas is the number of points of each dialer on the hash ring.
+const hashReplicas = 100
+
+type DialerGroupOptions struct {
+	Strategy BalanceStrategy
+	// StickySession keeps sources on the dialer of their last connection until idle for this time, disabled if zero.
+	// It is rounded up to whole seconds. A failed dial unsticks the source, so its next connection is balanced again.
+	StickySession time.Duration
+	// StickySessionSize is the maximum number of sticky sources, DefaultStickySessionSize if not positive.
+	StickySessionSize int
+}
+
+const DefaultStickySessionSize = 4096
+
+var _ Dialer = (*DialerGroup)(nil)
+
+// DialerGroup balances connections over dialers.
+//
+// The source of a connection is read from the metadata in the context, see M.ContextWithMetadata,
+// connections without one are balanced by round robin when the strategy or a sticky session needs it.
+//
+// With BalanceLeastActive, a connection counts as active from the start of its dial until it is closed.
+type DialerGroup struct {
+	dialers  []Dialer
+	strategy BalanceStrategy
+	next     uint32
+	active   []int64
+	ring     []hashPoint
+	sessions *cache.LruCache[string, int]
+}
+
+type hashPoint struct {
+	hash  uint32
+	index int
+}
+
+func NewDialerGroup(dialers []Dialer, options DialerGroupOptions) (*DialerGroup, error) {
+	if len(dialers) == 0 {
+		return nil, E.New("empty dialer group")
+	}
+	group := &DialerGroup{
+		dialers:  dialers,
+		strategy: options.Strategy,
+		active:   make([]int64, len(dialers)),
+	}
+	switch options.Strategy {
+	case BalanceRoundRobin, BalanceLeastActive:
+	case BalanceHashDestination, BalanceHashSource:
+		for index := range dialers {
+			for replica := 0; replica < hashReplicas; replica++ {
+				group.ring = append(group.ring, hashPoint{hashKey(F.ToString(index, "-", replica)), index})
+			}
+		}
+		sort.Slice(group.ring, func(i, j int) bool {
+			return group.ring[i].hash < group.ring[j].hash
+		})
+	default:
+		return nil, E.New("unknown balance strategy: ", options.Strategy)
+	}
+	if options.StickySession > 0 {
+		maxAge := int64((options.StickySession + time.Second - 1) / time.Second)
+		maxSize := options.StickySessionSize
+		if maxSize <= 0 {
+			maxSize = DefaultStickySessionSize
+		}
+		group.sessions = cache.New(cache.WithAge[string, int](maxAge), cache.WithUpdateAgeOnGet[string, int](), cache.WithSize[string, int](maxSize))
+	}
+	return group, nil
+}
+
+func (g *DialerGroup) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	source, index := g.selectDialer(ctx, destination)
+	if g.strategy != BalanceLeastActive {
+		conn, err := g.dialers[index].DialContext(ctx, network, destination)
+		if err != nil {
+			g.unstick(source, index)
+		}
+		return conn, err
+	}
+	counter := g.activeCounter(index)
+	conn, err := g.dialers[index].DialContext(ctx, network, destination)
+	if err != nil {
+		atomic.AddInt64(counter, -1)
+		g.unstick(source, index)
+		return nil, err
+	}
+	return &groupConn{Conn: conn, counter: counter}, nil
+}
+
+func (g *DialerGroup) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	source, index := g.selectDialer(ctx, destination)
+	if g.strategy != BalanceLeastActive {
+		conn, err := g.dialers[index].ListenPacket(ctx, destination)
+		if err != nil {
+			g.unstick(source, index)
+		}
+		return conn, err
+	}
+	counter := g.activeCounter(index)
+	conn, err := g.dialers[index].ListenPacket(ctx, destination)
+	if err != nil {
+		atomic.AddInt64(counter, -1)
+		g.unstick(source, index)
+		return nil, err
+	}
+	return &groupPacketConn{PacketConn: conn, counter: counter}, nil
+}
+
+// Dialers returns the members of the group.
+func (g *DialerGroup) Dialers() []Dialer {
+	return g.dialers
+}
+
+func (g *DialerGroup) selectDialer(ctx context.Context, destination M.Socksaddr) (source string, index int) {
+	if metadata, loaded := M.MetadataFromContext(ctx); loaded && metadata.Source.IsValid() {
+		source = metadata.Source.AddrString()
+	}
+	if g.sessions != nil && source != "" {
+		index, _ = g.sessions.LoadOrStore(source, func() int {
+			return g.balance(source, destination)
+		})
+		return
+	}
+	return source, g.balance(source, destination)
+}
+
+// unstick drops the sticky session of source after a failed dial on index, unless it moved already.
+func (g *DialerGroup) unstick(source string, index int) {
+	if g.sessions == nil || source == "" {
+		return
+	}
+	if stickyIndex, loaded := g.sessions.Load(source); loaded && stickyIndex == index {
+		g.sessions.Delete(source)
+	}
+}
+
+func (g *DialerGroup) balance(source string, destination M.Socksaddr) int {
+	switch g.strategy {
+	case BalanceLeastActive:
+		offset := int(atomic.AddUint32(&g.next, 1) % uint32(len(g.dialers)))
+		selected := -1
+		var selectedActive int64
+		for i := range g.dialers {
+			index := (offset + i) % len(g.dialers)
+			active := atomic.LoadInt64(&g.active[index])
+			if selected == -1 || active < selectedActive {
+				selected = index
+				selectedActive = active
+			}
+		}
+		return selected
+	case BalanceHashDestination:
+		return g.hashDialer(destination.AddrString())
+	case BalanceHashSource:
+		if source != "" {
+			return g.hashDialer(source)
+		}
+	}
+	return int((atomic.AddUint32(&g.next, 1) - 1) % uint32(len(g.dialers)))
+}
+
+func (g *DialerGroup) hashDialer(key string) int {
+	hash := hashKey(key)
+	position := sort.Search(len(g.ring), func(i int) bool {
+		return g.ring[i].hash >= hash
+	})
+	if position == len(g.ring) {
+		position = 0
+	}
+	return g.ring[position].index
+}
+
+func (g *DialerGroup) activeCounter(index int) *int64 {
+	counter := &g.active[index]
+	atomic.AddInt64(counter, 1)
+	return counter
+}
+
+func hashKey(key string) uint32 {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(key))
+	// fnv alone clusters keys differing in the last bytes, mix with the murmur3 finalizer
+	hash := hasher.Sum32()
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return hash
+}
+
+type groupConn struct {
+	net.Conn
+	counter   *int64
+	closeOnce sync.Once
+}
+
+func (c *groupConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(c.counter, -1)
+	})
+	return c.Conn.Close()
+}
+
+func (c *groupConn) Upstream() any {
+	return c.Conn
+}
+
+func (c *groupConn) ReaderReplaceable() bool {
+	return true
+}
+
+func (c *groupConn) WriterReplaceable() bool {
+	return true
+}
+
+type groupPacketConn struct {
+	net.PacketConn
+	counter   *int64
+	closeOnce sync.Once
+}
+
+func (c *groupPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(c.counter, -1)
+	})
+	return c.PacketConn.Close()
+}
+
+func (c *groupPacketConn) Upstream() any {
+	return c.PacketConn
+}
+
+func (c *groupPacketConn) ReaderReplaceable() bool {
+	return true
+}
+
+func (c *groupPacketConn) WriterReplaceable() bool {
+	return true
+}
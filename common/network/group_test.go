@@ -0,0 +1,46 @@
+package network_test
+
+import (
+	"context"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+func TestDialerGroupStickySessionFailure(t *testing.T) {
+	destination := M.SocksaddrFrom(testAddr4a, 443)
+	dead := &fakeDialer{results: map[netip.Addr]error{testAddr4a: io.ErrClosedPipe}}
+	alive := &fakeDialer{}
+	group, err := N.NewDialerGroup([]N.Dialer{dead, alive}, N.DialerGroupOptions{
+		Strategy:      N.BalanceRoundRobin,
+		StickySession: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := M.ContextWithMetadata(context.Background(), M.Metadata{
+		Source: M.ParseSocksaddr("10.0.0.1:1234"),
+	})
+	_, err = group.DialContext(ctx, "tcp", destination)
+	if err == nil {
+		t.Fatal("dial through the dead member succeeded")
+	}
+	// the failed session is dropped, so the source is balanced to the next member and sticks to it
+	for i := 0; i < 3; i++ {
+		conn, err := group.DialContext(ctx, "tcp", destination)
+		if err != nil {
+			t.Fatal("source stuck to the dead member: ", err)
+		}
+		conn.Close()
+	}
+	if dialed := len(dead.Dialed()); dialed != 1 {
+		t.Fatal("dead member dialed ", dialed, " times")
+	}
+	if dialed := len(alive.Dialed()); dialed != 3 {
+		t.Fatal("alive member dialed ", dialed, " times")
+	}
+}
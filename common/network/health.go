@@ -0,0 +1,281 @@
+package network
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+	"github.com/MehranF123/sing/common/observable"
+	"github.com/MehranF123/sing/common/task"
+)
+
+const (
+	DefaultHealthCheckInterval  = 30 * time.Second
+	DefaultHealthCheckTimeout   = 5 * time.Second
+	DefaultHealthCheckTolerance = 50 * time.Millisecond
+)
+
+type HealthCheckStrategy uint8
+
+const (
+	// HealthCheckFailover uses the first healthy dialer in order.
+	HealthCheckFailover HealthCheckStrategy = iota
+	// HealthCheckLowestLatency uses the healthy dialer with the lowest probe latency.
+	HealthCheckLowestLatency
+)
+
+type HealthCheckOptions struct {
+	Strategy HealthCheckStrategy
+	// Destination is dialed with tcp by every probe, the latency is the time until DialContext returns,
+	// which includes the handshake of proxy dialers.
+	Destination M.Socksaddr
+	Interval    time.Duration
+	Timeout     time.Duration
+	// Tolerance is how much faster another dialer must be to replace the selected one, which avoids flapping.
+	// DefaultHealthCheckTolerance if zero, a negative tolerance switches to any faster dialer.
+	Tolerance time.Duration
+}
+
+// HealthEvent is published when a dialer becomes healthy or unhealthy.
+type HealthEvent struct {
+	Index   int
+	Healthy bool
+	Latency time.Duration
+	Error   error
+}
+
+type healthState struct {
+	healthy bool
+	latency time.Duration
+}
+
+var _ Dialer = (*HealthCheckDialer)(nil)
+
+// HealthCheckDialer probes dialers periodically and dials with the selected healthy one,
+// a failed dial is retried with the next one.
+//
+// Only probes change the health of dialers, since a dial may also fail for its destination.
+type HealthCheckDialer struct {
+	dialers  []Dialer
+	options  HealthCheckOptions
+	access   sync.RWMutex
+	states   []healthState
+	selected int
+	observer *observable.Observer[HealthEvent]
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func NewHealthCheckDialer(dialers []Dialer, options HealthCheckOptions) (*HealthCheckDialer, error) {
+	if len(dialers) == 0 {
+		return nil, E.New("empty dialer list")
+	}
+	if !options.Destination.IsValid() {
+		return nil, E.New("missing health check destination")
+	}
+	switch options.Strategy {
+	case HealthCheckFailover, HealthCheckLowestLatency:
+	default:
+		return nil, E.New("unknown health check strategy: ", options.Strategy)
+	}
+	if options.Interval <= 0 {
+		options.Interval = DefaultHealthCheckInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultHealthCheckTimeout
+	}
+	if options.Tolerance == 0 {
+		options.Tolerance = DefaultHealthCheckTolerance
+	} else if options.Tolerance < 0 {
+		options.Tolerance = 0
+	}
+	states := make([]healthState, len(dialers))
+	for i := range states {
+		states[i].healthy = true
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HealthCheckDialer{
+		dialers:  dialers,
+		options:  options,
+		states:   states,
+		observer: observable.NewObserver[HealthEvent](observable.NewSubscriber[HealthEvent](16), 16),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// Start checks all dialers in the background immediately and then every interval until closed,
+// dialers are healthy until their first probe fails.
+func (d *HealthCheckDialer) Start() error {
+	if d.ctx.Err() != nil {
+		return os.ErrClosed
+	}
+	go d.loopCheck()
+	return nil
+}
+
+func (d *HealthCheckDialer) loopCheck() {
+	d.Check(d.ctx)
+	ticker := time.NewTicker(d.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.Check(d.ctx)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// Check probes all dialers concurrently and updates the selection.
+func (d *HealthCheckDialer) Check(ctx context.Context) error {
+	probes := make([]func() error, 0, len(d.dialers))
+	for i := range d.dialers {
+		index := i
+		probes = append(probes, func() error {
+			latency, err := d.probe(ctx, d.dialers[index])
+			d.update(index, latency, err)
+			return nil
+		})
+	}
+	return task.Run(ctx, probes...)
+}
+
+func (d *HealthCheckDialer) probe(ctx context.Context, dialer Dialer) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.options.Timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", d.options.Destination)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	conn.Close()
+	return latency, nil
+}
+
+func (d *HealthCheckDialer) update(index int, latency time.Duration, err error) {
+	d.access.Lock()
+	state := &d.states[index]
+	healthy := err == nil
+	changed := state.healthy != healthy
+	state.healthy = healthy
+	if healthy {
+		state.latency = latency
+	}
+	d.selectDialer()
+	d.access.Unlock()
+	if changed {
+		d.observer.Emit(HealthEvent{
+			Index:   index,
+			Healthy: healthy,
+			Latency: latency,
+			Error:   err,
+		})
+	}
+}
+
+func (d *HealthCheckDialer) selectDialer() {
+	switch d.options.Strategy {
+	case HealthCheckFailover:
+		for index, state := range d.states {
+			if state.healthy {
+				d.selected = index
+				return
+			}
+		}
+		d.selected = -1
+	case HealthCheckLowestLatency:
+		selected := -1
+		for index, state := range d.states {
+			if state.healthy && (selected == -1 || state.latency < d.states[selected].latency) {
+				selected = index
+			}
+		}
+		if selected == -1 || d.selected == -1 || !d.states[d.selected].healthy ||
+			d.states[selected].latency+d.options.Tolerance < d.states[d.selected].latency {
+			d.selected = selected
+		}
+	}
+}
+
+// Selected returns the index of the dialer used by the next dial, or -1 if no dialer is healthy.
+func (d *HealthCheckDialer) Selected() int {
+	d.access.RLock()
+	defer d.access.RUnlock()
+	return d.selected
+}
+
+// Latency returns the last probe latency of a dialer and whether it is healthy.
+func (d *HealthCheckDialer) Latency(index int) (time.Duration, bool) {
+	d.access.RLock()
+	defer d.access.RUnlock()
+	state := d.states[index]
+	return state.latency, state.healthy
+}
+
+// dialOrder returns the selected dialer followed by the other healthy ones and then the unhealthy ones.
+func (d *HealthCheckDialer) dialOrder() []int {
+	d.access.RLock()
+	defer d.access.RUnlock()
+	order := make([]int, 0, len(d.dialers))
+	if d.selected != -1 {
+		order = append(order, d.selected)
+	}
+	for _, healthy := range []bool{true, false} {
+		for index, state := range d.states {
+			if index != d.selected && state.healthy == healthy {
+				order = append(order, index)
+			}
+		}
+	}
+	return order
+}
+
+func (d *HealthCheckDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	var connErrors []error
+	for _, index := range d.dialOrder() {
+		conn, err := d.dialers[index].DialContext(ctx, network, destination)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		connErrors = append(connErrors, err)
+	}
+	return nil, E.Errors(connErrors...)
+}
+
+func (d *HealthCheckDialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	var connErrors []error
+	for _, index := range d.dialOrder() {
+		conn, err := d.dialers[index].ListenPacket(ctx, destination)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		connErrors = append(connErrors, err)
+	}
+	return nil, E.Errors(connErrors...)
+}
+
+func (d *HealthCheckDialer) Subscribe() (subscription observable.Subscription[HealthEvent], done <-chan struct{}, err error) {
+	return d.observer.Subscribe()
+}
+
+func (d *HealthCheckDialer) UnSubscribe(subscription observable.Subscription[HealthEvent]) {
+	d.observer.UnSubscribe(subscription)
+}
+
+func (d *HealthCheckDialer) Close() error {
+	d.cancel()
+	return d.observer.Close()
+}
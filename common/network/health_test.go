@@ -0,0 +1,265 @@
+package network_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MehranF123/sing/common"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+func listenEcho(t *testing.T) M.Socksaddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return M.SocksaddrFromNet(listener.Addr())
+}
+
+// testDialer dials with the system dialer, or to an in-memory echo if pipe is set, and fails while down.
+// Dials wait for gate to be closed if set, and closing the first dialed conn closes closed if set.
+type testDialer struct {
+	down       uint32
+	pipe       bool
+	gate       chan struct{}
+	closed     chan struct{}
+	closedOnce sync.Once
+}
+
+func (d *testDialer) setDown(down bool) {
+	var value uint32
+	if down {
+		value = 1
+	}
+	atomic.StoreUint32(&d.down, value)
+}
+
+func (d *testDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if atomic.LoadUint32(&d.down) == 1 {
+		return nil, io.ErrClosedPipe
+	}
+	if d.gate != nil {
+		select {
+		case <-d.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if d.pipe {
+		var peer net.Conn
+		conn, peer = net.Pipe()
+		go func() {
+			defer peer.Close()
+			io.Copy(peer, peer)
+		}()
+	} else {
+		conn, err = N.SystemDialer.DialContext(ctx, network, destination)
+	}
+	if err == nil && d.closed != nil {
+		conn = &testConn{conn, d}
+	}
+	return conn, err
+}
+
+type testConn struct {
+	net.Conn
+	dialer *testDialer
+}
+
+func (c *testConn) Close() error {
+	c.dialer.closedOnce.Do(func() {
+		close(c.dialer.closed)
+	})
+	return c.Conn.Close()
+}
+
+func (d *testDialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return N.SystemDialer.ListenPacket(ctx, destination)
+}
+
+func newHealthCheckDialer(t *testing.T, strategy N.HealthCheckStrategy, destination M.Socksaddr, dialers ...N.Dialer) *N.HealthCheckDialer {
+	dialer, err := N.NewHealthCheckDialer(dialers, N.HealthCheckOptions{
+		Strategy:    strategy,
+		Destination: destination,
+		Interval:    time.Hour,
+		Timeout:     time.Second,
+		Tolerance:   -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		dialer.Close()
+	})
+	return dialer
+}
+
+func check(t *testing.T, dialer *N.HealthCheckDialer) {
+	t.Helper()
+	err := dialer.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertSelected(t *testing.T, dialer *N.HealthCheckDialer, expected int) {
+	t.Helper()
+	if selected := dialer.Selected(); selected != expected {
+		t.Fatal("selected ", selected, ", expected ", expected)
+	}
+}
+
+func assertEcho(t *testing.T, dialer N.Dialer, destination M.Socksaddr) {
+	t.Helper()
+	conn, err := dialer.DialContext(context.Background(), "tcp", destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	message := []byte("ping")
+	_, err = conn.Write(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, len(message))
+	_, err = io.ReadFull(conn, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != string(message) {
+		t.Fatal("unexpected response: ", string(response))
+	}
+}
+
+func TestHealthCheckFailover(t *testing.T) {
+	destination := listenEcho(t)
+	primary, backup := &testDialer{}, &testDialer{}
+	dialer := newHealthCheckDialer(t, N.HealthCheckFailover, destination, primary, backup)
+	check(t, dialer)
+	assertSelected(t, dialer, 0)
+
+	primary.setDown(true)
+	check(t, dialer)
+	assertSelected(t, dialer, 1)
+	assertEcho(t, dialer, destination)
+
+	primary.setDown(false)
+	check(t, dialer)
+	assertSelected(t, dialer, 0)
+	assertEcho(t, dialer, destination)
+}
+
+func TestHealthCheckRecovery(t *testing.T) {
+	destination := listenEcho(t)
+	first, second := &testDialer{}, &testDialer{}
+	dialer := newHealthCheckDialer(t, N.HealthCheckLowestLatency, destination, first, second)
+	subscription, _, err := dialer.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.setDown(true)
+	second.setDown(true)
+	check(t, dialer)
+	assertSelected(t, dialer, -1)
+	_, err = dialer.DialContext(context.Background(), "tcp", destination)
+	if err == nil {
+		t.Fatal("dial succeeded without healthy dialers")
+	}
+
+	second.setDown(false)
+	check(t, dialer)
+	assertSelected(t, dialer, 1)
+	assertEcho(t, dialer, destination)
+
+	var events []N.HealthEvent
+	for len(events) < 3 {
+		select {
+		case event := <-subscription:
+			events = append(events, event)
+		case <-time.After(time.Second):
+			t.Fatal("missing health events: ", len(events))
+		}
+	}
+	if last := events[2]; last.Index != 1 || !last.Healthy {
+		t.Fatal("unexpected health event: ", last)
+	}
+}
+
+func TestHealthCheckLowestLatency(t *testing.T) {
+	destination := listenEcho(t)
+	// the fast probe closes its in-memory conn after measuring, the slow dialer waits for that before dialing tcp
+	gate := make(chan struct{})
+	slow, fast := &testDialer{gate: gate}, &testDialer{pipe: true, closed: gate}
+	dialer := newHealthCheckDialer(t, N.HealthCheckLowestLatency, destination, slow, fast)
+	check(t, dialer)
+	assertSelected(t, dialer, 1)
+	assertEcho(t, dialer, destination)
+
+	fast.setDown(true)
+	check(t, dialer)
+	assertSelected(t, dialer, 0)
+}
+
+func TestHealthCheckDialErrorKeepsHealth(t *testing.T) {
+	destination := listenEcho(t)
+	dialer := newHealthCheckDialer(t, N.HealthCheckFailover, destination, &testDialer{}, &testDialer{})
+	check(t, dialer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedDestination := M.SocksaddrFromNet(listener.Addr())
+	common.Must(listener.Close())
+	_, err = dialer.DialContext(context.Background(), "tcp", closedDestination)
+	if err == nil {
+		t.Fatal("dial to a closed port succeeded")
+	}
+	assertSelected(t, dialer, 0)
+	if _, healthy := dialer.Latency(0); !healthy {
+		t.Fatal("destination failure marked the dialer unhealthy")
+	}
+}
+
+func TestHealthCheckStartAsync(t *testing.T) {
+	destination := listenEcho(t)
+	gate, closed := make(chan struct{}), make(chan struct{})
+	dialer := newHealthCheckDialer(t, N.HealthCheckFailover, destination, &testDialer{gate: gate, closed: closed})
+	// the probe can only connect after Start returned, a blocking Start would time it out
+	err := dialer.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(gate)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("probe did not connect after Start")
+	}
+}
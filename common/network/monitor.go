@@ -0,0 +1,254 @@
+package network
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MehranF123/sing/common"
+	"github.com/MehranF123/sing/common/control"
+	F "github.com/MehranF123/sing/common/format"
+	M "github.com/MehranF123/sing/common/metadata"
+	"github.com/MehranF123/sing/common/observable"
+)
+
+const DefaultInterfacePollInterval = 5 * time.Second
+
+type InterfaceEventType uint8
+
+const (
+	// InterfaceEventLinkUpdate is set when interfaces or their addresses changed.
+	InterfaceEventLinkUpdate InterfaceEventType = 1 << iota
+	// InterfaceEventDefaultInterfaceUpdate is set when the default interface changed.
+	InterfaceEventDefaultInterfaceUpdate
+)
+
+// InterfaceEvent is the network state after a change, the default interface index is -1 without a default route.
+type InterfaceEvent struct {
+	Type                  InterfaceEventType
+	DefaultInterfaceName  string
+	DefaultInterfaceIndex int
+}
+
+// InterfaceEventSource watches the network for an InterfaceMonitor.
+type InterfaceEventSource interface {
+	// Start emits the current state before returning and then an event on every change until closed.
+	// The monitor sets InterfaceEventDefaultInterfaceUpdate itself.
+	Start(emit func(event InterfaceEvent)) error
+	Close() error
+}
+
+type InterfaceMonitor interface {
+	observable.Observable[InterfaceEvent]
+	Start() error
+	Close() error
+	DefaultInterfaceName() string
+	DefaultInterfaceIndex() int
+}
+
+type defaultInterfaceMonitor struct {
+	source                InterfaceEventSource
+	observer              *observable.Observer[InterfaceEvent]
+	access                sync.RWMutex
+	defaultInterfaceName  string
+	defaultInterfaceIndex int
+}
+
+// NewInterfaceMonitor creates a monitor publishing the events of source,
+// which defaults to netlink on Linux and to polling elsewhere or if netlink is unavailable.
+func NewInterfaceMonitor(source InterfaceEventSource) InterfaceMonitor {
+	if source == nil {
+		source = newPlatformInterfaceEventSource()
+	}
+	return &defaultInterfaceMonitor{
+		source:                source,
+		observer:              observable.NewObserver[InterfaceEvent](observable.NewSubscriber[InterfaceEvent](16), 16),
+		defaultInterfaceIndex: -1,
+	}
+}
+
+func (m *defaultInterfaceMonitor) Start() error {
+	return m.source.Start(m.emit)
+}
+
+func (m *defaultInterfaceMonitor) emit(event InterfaceEvent) {
+	m.access.Lock()
+	if event.DefaultInterfaceName != m.defaultInterfaceName || event.DefaultInterfaceIndex != m.defaultInterfaceIndex {
+		event.Type |= InterfaceEventDefaultInterfaceUpdate
+		m.defaultInterfaceName = event.DefaultInterfaceName
+		m.defaultInterfaceIndex = event.DefaultInterfaceIndex
+	}
+	m.access.Unlock()
+	if event.Type != 0 {
+		m.observer.Emit(event)
+	}
+}
+
+func (m *defaultInterfaceMonitor) Subscribe() (subscription observable.Subscription[InterfaceEvent], done <-chan struct{}, err error) {
+	return m.observer.Subscribe()
+}
+
+func (m *defaultInterfaceMonitor) UnSubscribe(subscription observable.Subscription[InterfaceEvent]) {
+	m.observer.UnSubscribe(subscription)
+}
+
+func (m *defaultInterfaceMonitor) DefaultInterfaceName() string {
+	m.access.RLock()
+	defer m.access.RUnlock()
+	return m.defaultInterfaceName
+}
+
+func (m *defaultInterfaceMonitor) DefaultInterfaceIndex() int {
+	m.access.RLock()
+	defer m.access.RUnlock()
+	return m.defaultInterfaceIndex
+}
+
+func (m *defaultInterfaceMonitor) Close() error {
+	return common.Close(m.source, m.observer)
+}
+
+// AutoUpdateBindManager updates manager on every event of monitor until the returned closer or the monitor is closed.
+func AutoUpdateBindManager(monitor InterfaceMonitor, manager control.BindManager) (io.Closer, error) {
+	subscription, done, err := monitor.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case <-subscription:
+				manager.Update()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return &bindManagerUpdater{monitor, subscription}, nil
+}
+
+type bindManagerUpdater struct {
+	monitor      InterfaceMonitor
+	subscription observable.Subscription[InterfaceEvent]
+}
+
+func (u *bindManagerUpdater) Close() error {
+	u.monitor.UnSubscribe(u.subscription)
+	return nil
+}
+
+// DefaultRouteProbeAddresses are the addresses the polling source finds the default route to.
+var DefaultRouteProbeAddresses = []M.Socksaddr{
+	M.ParseSocksaddr("1.1.1.1:53"),
+	M.ParseSocksaddr("[2606:4700:4700::1111]:53"),
+}
+
+type pollingInterfaceEventSource struct {
+	interval       time.Duration
+	probeAddresses []M.Socksaddr
+	done           chan struct{}
+	closeOnce      sync.Once
+}
+
+// NewPollingInterfaceEventSource creates a source comparing interfaces and the default route every interval.
+//
+// The default route is the route to the first reachable of probeAddresses, DefaultRouteProbeAddresses if empty.
+// It is looked up by connecting a UDP socket, which sends no packets.
+func NewPollingInterfaceEventSource(interval time.Duration, probeAddresses ...M.Socksaddr) InterfaceEventSource {
+	if interval <= 0 {
+		interval = DefaultInterfacePollInterval
+	}
+	if len(probeAddresses) == 0 {
+		probeAddresses = DefaultRouteProbeAddresses
+	}
+	return &pollingInterfaceEventSource{
+		interval:       interval,
+		probeAddresses: probeAddresses,
+		done:           make(chan struct{}),
+	}
+}
+
+func (s *pollingInterfaceEventSource) Start(emit func(event InterfaceEvent)) error {
+	snapshot, err := interfacesSnapshot()
+	if err != nil {
+		return err
+	}
+	defaultInterfaceName, defaultInterfaceIndex := routeDefaultInterface(s.probeAddresses)
+	emit(InterfaceEvent{
+		DefaultInterfaceName:  defaultInterfaceName,
+		DefaultInterfaceIndex: defaultInterfaceIndex,
+	})
+	go s.loopPoll(emit, snapshot)
+	return nil
+}
+
+func (s *pollingInterfaceEventSource) loopPoll(emit func(event InterfaceEvent), snapshot string) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		var event InterfaceEvent
+		newSnapshot, err := interfacesSnapshot()
+		if err == nil && newSnapshot != snapshot {
+			event.Type = InterfaceEventLinkUpdate
+			snapshot = newSnapshot
+		}
+		event.DefaultInterfaceName, event.DefaultInterfaceIndex = routeDefaultInterface(s.probeAddresses)
+		emit(event)
+	}
+}
+
+func (s *pollingInterfaceEventSource) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+func interfacesSnapshot() (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	var snapshot strings.Builder
+	for _, netInterface := range interfaces {
+		snapshot.WriteString(F.ToString(netInterface.Index, " ", netInterface.Name, " ", netInterface.Flags, "\n"))
+		addrs, _ := netInterface.Addrs()
+		for _, addr := range addrs {
+			snapshot.WriteString(F.ToString(" ", addr, "\n"))
+		}
+	}
+	return snapshot.String(), nil
+}
+
+// routeDefaultInterface finds the interface holding the local address of a UDP socket connected to a probe address.
+func routeDefaultInterface(probeAddresses []M.Socksaddr) (string, int) {
+	for _, destination := range probeAddresses {
+		conn, err := net.DialUDP("udp", nil, destination.UDPAddr())
+		if err != nil {
+			continue
+		}
+		localAddr := M.AddrFromNetAddr(conn.LocalAddr()).Unmap()
+		conn.Close()
+		interfaces, err := net.Interfaces()
+		if err != nil {
+			break
+		}
+		for _, netInterface := range interfaces {
+			addrs, _ := netInterface.Addrs()
+			for _, addr := range addrs {
+				if prefix, err := netip.ParsePrefix(addr.String()); err == nil && prefix.Addr() == localAddr {
+					return netInterface.Name, netInterface.Index
+				}
+			}
+		}
+	}
+	return "", -1
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	E "github.com/MehranF123/sing/common/exceptions"
+
+	"golang.org/x/sys/unix"
+)
+
+func newPlatformInterfaceEventSource() InterfaceEventSource {
+	source, err := newNetlinkInterfaceEventSource()
+	if err != nil {
+		return NewPollingInterfaceEventSource(0)
+	}
+	return source
+}
+
+type netlinkInterfaceEventSource struct {
+	file *os.File
+}
+
+func newNetlinkInterfaceEventSource() (*netlinkInterfaceEventSource, error) {
+	// route dumps may be denied even if the socket is allowed, e.g. on recent Android versions
+	_, _, err := netlinkDefaultInterface()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	err = unix.Bind(fd, &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR | unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE,
+	})
+	if err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	return &netlinkInterfaceEventSource{os.NewFile(uintptr(fd), "netlink")}, nil
+}
+
+func (s *netlinkInterfaceEventSource) Start(emit func(event InterfaceEvent)) error {
+	defaultInterfaceName, defaultInterfaceIndex, err := netlinkDefaultInterface()
+	if err != nil {
+		return err
+	}
+	emit(InterfaceEvent{
+		DefaultInterfaceName:  defaultInterfaceName,
+		DefaultInterfaceIndex: defaultInterfaceIndex,
+	})
+	go s.loopRead(emit)
+	return nil
+}
+
+func (s *netlinkInterfaceEventSource) loopRead(emit func(event InterfaceEvent)) {
+	buffer := make([]byte, 65536)
+	for {
+		var event InterfaceEvent
+		n, err := s.file.Read(buffer)
+		if err != nil {
+			if !E.IsMulti(err, unix.ENOBUFS) {
+				return
+			}
+			// the receive queue overflowed and events were lost
+			event.Type = InterfaceEventLinkUpdate
+		} else {
+			messages, err := syscall.ParseNetlinkMessage(buffer[:n])
+			if err != nil {
+				continue
+			}
+			var routeUpdated bool
+			for _, message := range messages {
+				switch message.Header.Type {
+				case unix.RTM_NEWLINK, unix.RTM_DELLINK, unix.RTM_NEWADDR, unix.RTM_DELADDR:
+					event.Type |= InterfaceEventLinkUpdate
+				case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+					routeUpdated = true
+				}
+			}
+			if event.Type == 0 && !routeUpdated {
+				continue
+			}
+		}
+		event.DefaultInterfaceName, event.DefaultInterfaceIndex, err = netlinkDefaultInterface()
+		if err != nil {
+			continue
+		}
+		emit(event)
+	}
+}
+
+func (s *netlinkInterfaceEventSource) Close() error {
+	return s.file.Close()
+}
+
+// netlinkDefaultInterface returns the interface of the IPv4 default route with the lowest metric in the main table,
+// or of the IPv6 one if there is no IPv4 default route.
+func netlinkDefaultInterface() (string, int, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETROUTE, unix.AF_UNSPEC)
+	if err != nil {
+		return "", -1, os.NewSyscallError("netlinkrib", err)
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return "", -1, os.NewSyscallError("parsenetlinkmessage", err)
+	}
+	var (
+		defaultIndex  = -1
+		defaultFamily uint8
+		defaultMetric uint32
+	)
+	for _, message := range messages {
+		if message.Header.Type != unix.RTM_NEWROUTE || len(message.Data) < unix.SizeofRtMsg {
+			continue
+		}
+		routeMessage := (*unix.RtMsg)(unsafe.Pointer(&message.Data[0]))
+		if routeMessage.Dst_len != 0 || routeMessage.Table != unix.RT_TABLE_MAIN || routeMessage.Type != unix.RTN_UNICAST {
+			continue
+		}
+		attributes, err := syscall.ParseNetlinkRouteAttr(&message)
+		if err != nil {
+			continue
+		}
+		index := -1
+		var metric uint32
+		for _, attribute := range attributes {
+			if len(attribute.Value) < 4 {
+				continue
+			}
+			// attribute values are in native byte order
+			switch attribute.Attr.Type {
+			case unix.RTA_OIF:
+				index = int(*(*uint32)(unsafe.Pointer(&attribute.Value[0])))
+			case unix.RTA_PRIORITY:
+				metric = *(*uint32)(unsafe.Pointer(&attribute.Value[0]))
+			}
+		}
+		if index == -1 {
+			continue
+		}
+		if defaultIndex == -1 ||
+			routeMessage.Family == unix.AF_INET && defaultFamily != unix.AF_INET ||
+			routeMessage.Family == defaultFamily && metric < defaultMetric {
+			defaultIndex = index
+			defaultFamily = routeMessage.Family
+			defaultMetric = metric
+		}
+	}
+	if defaultIndex == -1 {
+		return "", -1, nil
+	}
+	netInterface, err := net.InterfaceByIndex(defaultIndex)
+	if err != nil {
+		return "", -1, err
+	}
+	return netInterface.Name, defaultIndex, nil
+}
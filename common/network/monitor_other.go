@@ -0,0 +1,7 @@
+//go:build !linux
+
+package network
+
+func newPlatformInterfaceEventSource() InterfaceEventSource {
+	return NewPollingInterfaceEventSource(0)
+}
@@ -0,0 +1,143 @@
+package network_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	N "github.com/MehranF123/sing/common/network"
+	"github.com/MehranF123/sing/common/observable"
+)
+
+// fakeEventSource emits an initial state on Start and then the events injected by the test.
+type fakeEventSource struct {
+	access  sync.Mutex
+	initial N.InterfaceEvent
+	emit    func(event N.InterfaceEvent)
+}
+
+func (s *fakeEventSource) Start(emit func(event N.InterfaceEvent)) error {
+	s.access.Lock()
+	s.emit = emit
+	s.access.Unlock()
+	emit(s.initial)
+	return nil
+}
+
+func (s *fakeEventSource) inject(event N.InterfaceEvent) {
+	s.access.Lock()
+	emit := s.emit
+	s.access.Unlock()
+	emit(event)
+}
+
+func (s *fakeEventSource) Close() error {
+	return nil
+}
+
+// fakeBindManager reports every Update on updates.
+type fakeBindManager struct {
+	updates chan struct{}
+}
+
+func (m *fakeBindManager) IndexByName(name string) (int, error) {
+	return 0, nil
+}
+
+func (m *fakeBindManager) Update() error {
+	m.updates <- struct{}{}
+	return nil
+}
+
+func waitUpdate(t *testing.T, manager *fakeBindManager) {
+	t.Helper()
+	select {
+	case <-manager.updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bind manager not updated")
+	}
+}
+
+func waitEvent(t *testing.T, subscription observable.Subscription[N.InterfaceEvent]) N.InterfaceEvent {
+	t.Helper()
+	select {
+	case event := <-subscription:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("missing interface event")
+		return N.InterfaceEvent{}
+	}
+}
+
+func TestAutoUpdateBindManager(t *testing.T) {
+	source := &fakeEventSource{initial: N.InterfaceEvent{
+		DefaultInterfaceName:  "eth0",
+		DefaultInterfaceIndex: 1,
+	}}
+	monitor := N.NewInterfaceMonitor(source)
+	defer monitor.Close()
+	manager := &fakeBindManager{updates: make(chan struct{}, 16)}
+	updater, err := N.AutoUpdateBindManager(monitor, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, _, err := monitor.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.UnSubscribe(subscription)
+
+	err = monitor.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitUpdate(t, manager)
+	if event := waitEvent(t, subscription); event.Type != N.InterfaceEventDefaultInterfaceUpdate || event.DefaultInterfaceName != "eth0" {
+		t.Fatal("unexpected initial event ", event)
+	}
+	if monitor.DefaultInterfaceName() != "eth0" || monitor.DefaultInterfaceIndex() != 1 {
+		t.Fatal("initial default interface ", monitor.DefaultInterfaceName(), " ", monitor.DefaultInterfaceIndex())
+	}
+
+	source.inject(N.InterfaceEvent{
+		Type:                  N.InterfaceEventLinkUpdate,
+		DefaultInterfaceName:  "eth0",
+		DefaultInterfaceIndex: 1,
+	})
+	waitUpdate(t, manager)
+	if event := waitEvent(t, subscription); event.Type != N.InterfaceEventLinkUpdate {
+		t.Fatal("unexpected event type ", event.Type)
+	}
+
+	// an unchanged state is not published, so the next event is the default interface change
+	source.inject(N.InterfaceEvent{
+		DefaultInterfaceName:  "eth0",
+		DefaultInterfaceIndex: 1,
+	})
+	source.inject(N.InterfaceEvent{
+		DefaultInterfaceName:  "wlan0",
+		DefaultInterfaceIndex: 2,
+	})
+	waitUpdate(t, manager)
+	if event := waitEvent(t, subscription); event.Type != N.InterfaceEventDefaultInterfaceUpdate || event.DefaultInterfaceName != "wlan0" {
+		t.Fatal("unexpected event ", event)
+	}
+	if monitor.DefaultInterfaceName() != "wlan0" || monitor.DefaultInterfaceIndex() != 2 {
+		t.Fatal("default interface not updated: ", monitor.DefaultInterfaceName(), " ", monitor.DefaultInterfaceIndex())
+	}
+
+	err = updater.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.inject(N.InterfaceEvent{
+		Type:                  N.InterfaceEventLinkUpdate,
+		DefaultInterfaceName:  "wlan0",
+		DefaultInterfaceIndex: 2,
+	})
+	// events are handed to all subscribers at once, so the closed updater has missed this one
+	waitEvent(t, subscription)
+	if len(manager.updates) != 0 {
+		t.Fatal("bind manager updated after close")
+	}
+}
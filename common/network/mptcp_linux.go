@@ -0,0 +1,157 @@
+package network
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+
+	"golang.org/x/sys/unix"
+)
+
+func multipathTCPSocket(address netip.Addr) (fd int, network string, err error) {
+	family := unix.AF_INET6
+	network = "tcp6"
+	if address.Is4() {
+		family = unix.AF_INET
+		network = "tcp4"
+	}
+	fd, err = unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.IPPROTO_MPTCP)
+	if err != nil {
+		switch err {
+		case unix.EPROTONOSUPPORT, unix.EINVAL, unix.ENOPROTOOPT:
+			return -1, "", E.Extend(ErrMultipathTCPUnavailable, err.Error())
+		}
+		return -1, "", os.NewSyscallError("socket", err)
+	}
+	return
+}
+
+func multipathTCPSockaddr(address netip.AddrPort) unix.Sockaddr {
+	if address.Addr().Is4() {
+		return &unix.SockaddrInet4{Port: int(address.Port()), Addr: address.Addr().As4()}
+	}
+	return &unix.SockaddrInet6{Port: int(address.Port()), Addr: address.Addr().As16()}
+}
+
+// dialMultipathTCP connects a Multipath TCP socket to the IP destination with the socket options of dialer,
+// resolving and timeouts are left to the caller.
+func dialMultipathTCP(ctx context.Context, dialer *net.Dialer, destination M.Socksaddr) (net.Conn, error) {
+	destination = destination.Unwrap()
+	fd, network, err := multipathTCPSocket(destination.Addr)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "mptcp")
+	defer file.Close()
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	if dialer.Control != nil {
+		err = dialer.Control(network, destination.String(), rawConn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tcpAddr, isTCPAddr := dialer.LocalAddr.(*net.TCPAddr); isTCPAddr && tcpAddr != nil {
+		err = unix.Bind(fd, multipathTCPSockaddr(M.SocksaddrFromNet(tcpAddr).Unwrap().AddrPort()))
+		if err != nil {
+			return nil, os.NewSyscallError("bind", err)
+		}
+	}
+	err = unix.Connect(fd, multipathTCPSockaddr(destination.AddrPort()))
+	if err != nil && err != unix.EINPROGRESS {
+		return nil, os.NewSyscallError("connect", err)
+	}
+	if err == unix.EINPROGRESS {
+		if deadline, loaded := ctx.Deadline(); loaded {
+			file.SetWriteDeadline(deadline)
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				file.SetWriteDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+		var innerErr error
+		err = rawConn.Write(func(fd uintptr) (done bool) {
+			var errno int
+			errno, innerErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+			if innerErr != nil {
+				return true
+			}
+			if errno != 0 {
+				innerErr = syscall.Errno(errno)
+				return innerErr != unix.EINPROGRESS && innerErr != unix.EALREADY
+			}
+			// spurious wakeup before the connection is established
+			_, innerErr = unix.Getpeername(int(fd))
+			return innerErr != unix.ENOTCONN
+		})
+		if innerErr != nil {
+			err = innerErr
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: destination.TCPAddr(), Err: err}
+		}
+	}
+	conn, err := net.FileConn(file)
+	if err != nil {
+		return nil, err
+	}
+	if dialer.KeepAlive >= 0 {
+		keepAlive := dialer.KeepAlive
+		if keepAlive == 0 {
+			keepAlive = 15 * time.Second
+		}
+		if tcpConn, isTCPConn := conn.(*net.TCPConn); isTCPConn {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(keepAlive)
+		}
+	}
+	return conn, nil
+}
+
+func listenMultipathTCP(listenConfig *net.ListenConfig, address netip.AddrPort) (net.Listener, error) {
+	fd, network, err := multipathTCPSocket(address.Addr())
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "mptcp")
+	defer file.Close()
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+	if err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if listenConfig.Control != nil {
+		err = listenConfig.Control(network, address.String(), rawConn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = unix.Bind(fd, multipathTCPSockaddr(address))
+	if err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	err = unix.Listen(fd, unix.SOMAXCONN)
+	if err != nil {
+		return nil, os.NewSyscallError("listen", err)
+	}
+	return net.FileListener(file)
+}
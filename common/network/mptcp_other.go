@@ -0,0 +1,20 @@
+//go:build !linux
+
+package network
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+)
+
+func dialMultipathTCP(ctx context.Context, dialer *net.Dialer, destination M.Socksaddr) (net.Conn, error) {
+	return nil, E.Extend(ErrMultipathTCPUnavailable, "unsupported platform")
+}
+
+func listenMultipathTCP(listenConfig *net.ListenConfig, address netip.AddrPort) (net.Listener, error) {
+	return nil, E.Extend(ErrMultipathTCPUnavailable, "unsupported platform")
+}
@@ -11,7 +11,10 @@ import (
 	M "github.com/MehranF123/sing/common/metadata"
 )
 
-const DefaultFallbackDelay = 300 * time.Millisecond
+const (
+	DefaultFallbackDelay = 300 * time.Millisecond
+	DefaultAttemptDelay  = 250 * time.Millisecond
+)
 
 func DialSerial(ctx context.Context, dialer Dialer, network string, destination M.Socksaddr, destinationAddresses []netip.Addr) (net.Conn, error) {
 	var conn net.Conn
@@ -43,82 +46,173 @@ func ListenSerial(ctx context.Context, dialer Dialer, destination M.Socksaddr, d
 	return nil, E.Errors(connErrors...)
 }
 
+// DialParallel dials with DialParallelWithOptions, addresses of the other family are tried
+// after fallbackDelay or as soon as the preferred family has failed, DefaultFallbackDelay if zero.
 func DialParallel(ctx context.Context, dialer Dialer, network string, destination M.Socksaddr, destinationAddresses []netip.Addr, preferIPv6 bool, fallbackDelay time.Duration) (net.Conn, error) {
-	// kanged form net.Dial
-
 	if fallbackDelay == 0 {
 		fallbackDelay = DefaultFallbackDelay
 	}
+	return DialParallelWithOptions(ctx, dialer, network, destination, destinationAddresses, DialParallelOptions{
+		PreferIPv6:    preferIPv6,
+		FallbackDelay: fallbackDelay,
+	})
+}
 
-	returned := make(chan struct{})
-	defer close(returned)
+// DialAttempt reports a finished connection attempt of DialParallelWithOptions.
+// Attempts cancelled because another one won have Canceled set.
+type DialAttempt struct {
+	Address  netip.Addr
+	Start    time.Time
+	Duration time.Duration
+	Error    error
+	Canceled bool
+}
 
-	addresses4 := common.Filter(destinationAddresses, func(address netip.Addr) bool {
-		return address.Is4() || address.Is4In6()
-	})
-	addresses6 := common.Filter(destinationAddresses, func(address netip.Addr) bool {
-		return address.Is6() && !address.Is4In6()
-	})
-	if len(addresses4) == 0 || len(addresses6) == 0 {
-		return DialSerial(ctx, dialer, network, destination, destinationAddresses)
+type DialParallelOptions struct {
+	PreferIPv6 bool
+	// AttemptDelay is the time to wait for a pending attempt before starting the next one, DefaultAttemptDelay if zero.
+	AttemptDelay time.Duration
+	// FirstFamilyCount is the number of addresses of the preferred family tried before switching family, 1 if zero.
+	FirstFamilyCount int
+	// FallbackDelay is the minimum time from the first attempt before an attempt of the other family starts
+	// while attempts are pending, no extra delay if zero.
+	FallbackDelay time.Duration
+	// OnAttempt is called from the dialing goroutine after every attempt.
+	OnAttempt func(attempt DialAttempt)
+}
+
+// DialParallelWithOptions dials with Happy Eyeballs v2 (RFC 8305): address families are interleaved,
+// a new attempt starts every AttemptDelay or as soon as the previous one fails,
+// attempts of the other family not before FallbackDelay,
+// and pending attempts are cancelled after the first success.
+func DialParallelWithOptions(ctx context.Context, dialer Dialer, network string, destination M.Socksaddr, destinationAddresses []netip.Addr, options DialParallelOptions) (net.Conn, error) {
+	attemptDelay := options.AttemptDelay
+	if attemptDelay == 0 {
+		attemptDelay = DefaultAttemptDelay
 	}
-	var primaries, fallbacks []netip.Addr
-	if preferIPv6 {
-		primaries = addresses6
-		fallbacks = addresses4
-	} else {
-		primaries = addresses4
-		fallbacks = addresses6
+	addresses := sortAddresses(destinationAddresses, options.PreferIPv6, options.FirstFamilyCount)
+	if len(addresses) == 0 {
+		return nil, E.New("no destination addresses")
 	}
+
+	returned := make(chan struct{})
+	defer close(returned)
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	type dialResult struct {
 		net.Conn
 		error
-		primary bool
-		done    bool
 	}
 	results := make(chan dialResult) // unbuffered
-	startRacer := func(ctx context.Context, primary bool) {
-		ras := primaries
-		if !primary {
-			ras = fallbacks
+	startAttempt := func(address netip.Addr) {
+		start := time.Now()
+		conn, err := dialer.DialContext(dialCtx, network, M.SocksaddrFrom(address, destination.Port))
+		if options.OnAttempt != nil {
+			options.OnAttempt(DialAttempt{
+				Address:  address,
+				Start:    start,
+				Duration: time.Since(start),
+				Error:    err,
+				Canceled: err != nil && dialCtx.Err() != nil && ctx.Err() == nil,
+			})
 		}
-		c, err := DialSerial(ctx, dialer, network, destination, ras)
 		select {
-		case results <- dialResult{Conn: c, error: err, primary: primary, done: true}:
+		case results <- dialResult{conn, err}:
 		case <-returned:
-			if c != nil {
-				c.Close()
+			if conn != nil {
+				conn.Close()
 			}
 		}
 	}
-	var primary, fallback dialResult
-	primaryCtx, primaryCancel := context.WithCancel(ctx)
-	defer primaryCancel()
-	go startRacer(primaryCtx, true)
-	fallbackTimer := time.NewTimer(fallbackDelay)
-	defer fallbackTimer.Stop()
-	for {
-		select {
-		case <-fallbackTimer.C:
-			fallbackCtx, fallbackCancel := context.WithCancel(ctx)
-			defer fallbackCancel()
-			go startRacer(fallbackCtx, false)
 
-		case res := <-results:
-			if res.error == nil {
-				return res.Conn, nil
+	var (
+		connErrors []error
+		next       int
+		pending    int
+	)
+	fallbackStart := time.Now().Add(options.FallbackDelay)
+	primaryIs4 := addresses[0].Is4() || addresses[0].Is4In6()
+	attemptTimer := time.NewTimer(attemptDelay)
+	defer attemptTimer.Stop()
+	startNext := func() {
+		go startAttempt(addresses[next])
+		next++
+		pending++
+		if !attemptTimer.Stop() {
+			select {
+			case <-attemptTimer.C:
+			default:
 			}
-			if res.primary {
-				primary = res
-			} else {
-				fallback = res
+		}
+		if next < len(addresses) {
+			delay := attemptDelay
+			if address := addresses[next]; (address.Is4() || address.Is4In6()) != primaryIs4 {
+				if untilFallback := time.Until(fallbackStart); untilFallback > delay {
+					delay = untilFallback
+				}
+			}
+			attemptTimer.Reset(delay)
+		}
+	}
+	startNext()
+	for {
+		select {
+		case <-attemptTimer.C:
+			if next < len(addresses) {
+				startNext()
 			}
-			if primary.done && fallback.done {
-				return nil, primary.error
+		case result := <-results:
+			pending--
+			if result.error == nil {
+				return result.Conn, nil
 			}
-			if res.primary && fallbackTimer.Stop() {
-				fallbackTimer.Reset(0)
+			connErrors = append(connErrors, result.error)
+			if next < len(addresses) {
+				startNext()
+			} else if pending == 0 {
+				return nil, E.Errors(connErrors...)
 			}
+		case <-ctx.Done():
+			return nil, E.Errors(append(connErrors, ctx.Err())...)
+		}
+	}
+}
+
+func sortAddresses(addresses []netip.Addr, preferIPv6 bool, firstFamilyCount int) []netip.Addr {
+	if firstFamilyCount <= 0 {
+		firstFamilyCount = 1
+	}
+	addresses4 := common.Filter(addresses, func(address netip.Addr) bool {
+		return address.Is4() || address.Is4In6()
+	})
+	addresses6 := common.Filter(addresses, func(address netip.Addr) bool {
+		return address.Is6() && !address.Is4In6()
+	})
+	primaries, fallbacks := addresses4, addresses6
+	if preferIPv6 {
+		primaries, fallbacks = addresses6, addresses4
+	}
+	if len(primaries) == 0 {
+		primaries, fallbacks = fallbacks, primaries
+	}
+	sorted := make([]netip.Addr, 0, len(addresses))
+	if len(primaries) > firstFamilyCount {
+		sorted = append(sorted, primaries[:firstFamilyCount]...)
+		primaries = primaries[firstFamilyCount:]
+	} else {
+		sorted = append(sorted, primaries...)
+		primaries = nil
+	}
+	for len(primaries) > 0 || len(fallbacks) > 0 {
+		if len(fallbacks) > 0 {
+			sorted = append(sorted, fallbacks[0])
+			fallbacks = fallbacks[1:]
+		}
+		if len(primaries) > 0 {
+			sorted = append(sorted, primaries[0])
+			primaries = primaries[1:]
 		}
 	}
+	return sorted
 }
@@ -0,0 +1,172 @@
+package network_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+var errHang = errors.New("hang")
+
+// fakeDialer records dialed addresses and returns the configured result for each,
+// errHang blocks until the attempt is cancelled and addresses without a result succeed.
+// Packet listeners are recorded and always fail.
+type fakeDialer struct {
+	access  sync.Mutex
+	results map[netip.Addr]error
+	dialed  []netip.Addr
+}
+
+func (d *fakeDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	d.access.Lock()
+	d.dialed = append(d.dialed, destination.Addr)
+	err := d.results[destination.Addr]
+	d.access.Unlock()
+	switch err {
+	case nil:
+		conn, peer := net.Pipe()
+		peer.Close()
+		return conn, nil
+	case errHang:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	default:
+		return nil, err
+	}
+}
+
+func (d *fakeDialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	d.access.Lock()
+	d.dialed = append(d.dialed, destination.Addr)
+	d.access.Unlock()
+	return nil, io.ErrClosedPipe
+}
+
+func (d *fakeDialer) Dialed() []netip.Addr {
+	d.access.Lock()
+	defer d.access.Unlock()
+	return append([]netip.Addr(nil), d.dialed...)
+}
+
+var (
+	testAddr4a = netip.MustParseAddr("192.0.2.1")
+	testAddr4b = netip.MustParseAddr("192.0.2.2")
+	testAddr6a = netip.MustParseAddr("2001:db8::1")
+	testAddr6b = netip.MustParseAddr("2001:db8::2")
+)
+
+func assertDialed(t *testing.T, dialed []netip.Addr, expected ...netip.Addr) {
+	t.Helper()
+	if len(dialed) != len(expected) {
+		t.Fatal("dialed ", dialed, ", expected ", expected)
+	}
+	for i := range expected {
+		if dialed[i] != expected[i] {
+			t.Fatal("dialed ", dialed, ", expected ", expected)
+		}
+	}
+}
+
+func TestDialParallelOrder(t *testing.T) {
+	addresses := []netip.Addr{testAddr4a, testAddr4b, testAddr6a, testAddr6b}
+	for _, test := range []struct {
+		name             string
+		preferIPv6       bool
+		firstFamilyCount int
+		expected         []netip.Addr
+	}{
+		{"interleave", false, 0, []netip.Addr{testAddr4a, testAddr6a, testAddr4b, testAddr6b}},
+		{"prefer ipv6", true, 0, []netip.Addr{testAddr6a, testAddr4a, testAddr6b, testAddr4b}},
+		{"first family count", false, 2, []netip.Addr{testAddr4a, testAddr4b, testAddr6a, testAddr6b}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			last := test.expected[len(test.expected)-1]
+			dialer := &fakeDialer{results: make(map[netip.Addr]error)}
+			for _, address := range addresses {
+				if address != last {
+					dialer.results[address] = io.ErrClosedPipe
+				}
+			}
+			// failures start the next attempt at once, so the order does not depend on timers
+			conn, err := N.DialParallelWithOptions(context.Background(), dialer, "tcp", M.Socksaddr{Port: 443}, addresses, N.DialParallelOptions{
+				PreferIPv6:       test.preferIPv6,
+				AttemptDelay:     time.Hour,
+				FirstFamilyCount: test.firstFamilyCount,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			assertDialed(t, dialer.Dialed(), test.expected...)
+		})
+	}
+}
+
+func TestDialParallelOnAttempt(t *testing.T) {
+	dialer := &fakeDialer{results: map[netip.Addr]error{
+		testAddr4a: errHang,
+		testAddr6a: io.ErrClosedPipe,
+	}}
+	attempts := make(chan N.DialAttempt, 3)
+	conn, err := N.DialParallelWithOptions(context.Background(), dialer, "tcp", M.Socksaddr{Port: 443}, []netip.Addr{testAddr4a, testAddr4b, testAddr6a}, N.DialParallelOptions{
+		AttemptDelay: time.Millisecond,
+		OnAttempt: func(attempt N.DialAttempt) {
+			attempts <- attempt
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	assertDialed(t, dialer.Dialed(), testAddr4a, testAddr6a, testAddr4b)
+	reported := make(map[netip.Addr]N.DialAttempt)
+	for i := 0; i < 3; i++ {
+		select {
+		case attempt := <-attempts:
+			reported[attempt.Address] = attempt
+		case <-time.After(5 * time.Second):
+			t.Fatal("missing attempts: ", reported)
+		}
+	}
+	if attempt := reported[testAddr4a]; attempt.Error == nil || !attempt.Canceled {
+		t.Fatal("pending attempt not reported as cancelled: ", attempt)
+	}
+	if attempt := reported[testAddr6a]; !errors.Is(attempt.Error, io.ErrClosedPipe) || attempt.Canceled {
+		t.Fatal("failed attempt misreported: ", attempt)
+	}
+	if attempt := reported[testAddr4b]; attempt.Error != nil || attempt.Canceled {
+		t.Fatal("successful attempt misreported: ", attempt)
+	}
+}
+
+func TestDialParallelFallbackDelay(t *testing.T) {
+	dialer := &fakeDialer{results: map[netip.Addr]error{
+		testAddr4a: io.ErrClosedPipe,
+	}}
+	// the fallback family is tried at once when the preferred family has failed
+	conn, err := N.DialParallel(context.Background(), dialer, "tcp", M.Socksaddr{Port: 443}, []netip.Addr{testAddr4a, testAddr6a}, false, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	assertDialed(t, dialer.Dialed(), testAddr4a, testAddr6a)
+
+	dialer = &fakeDialer{results: map[netip.Addr]error{
+		testAddr4a: errHang,
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*N.DefaultAttemptDelay)
+	defer cancel()
+	_, err = N.DialParallel(ctx, dialer, "tcp", M.Socksaddr{Port: 443}, []netip.Addr{testAddr4a, testAddr6a}, false, time.Hour)
+	if err == nil {
+		t.Fatal("dial succeeded before the fallback delay")
+	}
+	assertDialed(t, dialer.Dialed(), testAddr4a)
+}
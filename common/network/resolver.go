@@ -0,0 +1,164 @@
+package network
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/MehranF123/sing/common"
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+)
+
+// Resolver looks up addresses of a domain, network is one of "ip", "ip4" or "ip6".
+//
+// *net.Resolver implements it.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network string, host string) ([]netip.Addr, error)
+}
+
+var SystemResolver Resolver = net.DefaultResolver
+
+type DomainStrategy uint8
+
+const (
+	DomainStrategyAsIS DomainStrategy = iota
+	DomainStrategyPreferIPv4
+	DomainStrategyPreferIPv6
+	DomainStrategyUseIPv4
+	DomainStrategyUseIPv6
+)
+
+func (s DomainStrategy) String() string {
+	switch s {
+	case DomainStrategyAsIS:
+		return "as_is"
+	case DomainStrategyPreferIPv4:
+		return "prefer_ipv4"
+	case DomainStrategyPreferIPv6:
+		return "prefer_ipv6"
+	case DomainStrategyUseIPv4:
+		return "ipv4_only"
+	case DomainStrategyUseIPv6:
+		return "ipv6_only"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseDomainStrategy(strategy string) (DomainStrategy, error) {
+	switch strategy {
+	case "", "as_is":
+		return DomainStrategyAsIS, nil
+	case "prefer_ipv4":
+		return DomainStrategyPreferIPv4, nil
+	case "prefer_ipv6":
+		return DomainStrategyPreferIPv6, nil
+	case "ipv4_only":
+		return DomainStrategyUseIPv4, nil
+	case "ipv6_only":
+		return DomainStrategyUseIPv6, nil
+	}
+	return 0, E.New("unknown domain strategy: ", strategy)
+}
+
+// Lookup resolves domain with resolver and orders the result by strategy, preferred family first.
+func Lookup(ctx context.Context, resolver Resolver, domain string, strategy DomainStrategy) ([]netip.Addr, error) {
+	var network string
+	switch strategy {
+	case DomainStrategyUseIPv4:
+		network = "ip4"
+	case DomainStrategyUseIPv6:
+		network = "ip6"
+	default:
+		network = "ip"
+	}
+	addresses, err := resolver.LookupNetIP(ctx, network, domain)
+	if err != nil {
+		return nil, err
+	}
+	addresses = common.Map(addresses, netip.Addr.Unmap)
+	switch strategy {
+	case DomainStrategyUseIPv4:
+		addresses = common.Filter(addresses, netip.Addr.Is4)
+	case DomainStrategyUseIPv6:
+		addresses = common.Filter(addresses, netip.Addr.Is6)
+	case DomainStrategyPreferIPv4:
+		addresses = append(common.Filter(addresses, netip.Addr.Is4), common.Filter(addresses, netip.Addr.Is6)...)
+	case DomainStrategyPreferIPv6:
+		addresses = append(common.Filter(addresses, netip.Addr.Is6), common.Filter(addresses, netip.Addr.Is4)...)
+	}
+	if len(addresses) == 0 {
+		return nil, E.New("lookup ", domain, ": no ", strategy, " address")
+	}
+	return addresses, nil
+}
+
+var _ Dialer = (*ResolveDialer)(nil)
+
+// ResolveDialer resolves domain destinations with resolver before dialing.
+//
+// Connections race the resolved addresses with DialParallel, packet listeners try them with ListenSerial.
+type ResolveDialer struct {
+	dialer        Dialer
+	resolver      Resolver
+	strategy      DomainStrategy
+	fallbackDelay time.Duration
+}
+
+// NewResolveDialer creates a resolving dialer, resolver defaults to SystemResolver if nil.
+func NewResolveDialer(dialer Dialer, resolver Resolver, strategy DomainStrategy, fallbackDelay time.Duration) *ResolveDialer {
+	if resolver == nil {
+		resolver = SystemResolver
+	}
+	return &ResolveDialer{
+		dialer:        dialer,
+		resolver:      resolver,
+		strategy:      strategy,
+		fallbackDelay: fallbackDelay,
+	}
+}
+
+func (d *ResolveDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if !destination.IsFqdn() {
+		return d.dialer.DialContext(ctx, network, destination)
+	}
+	addresses, err := Lookup(ctx, d.resolver, destination.Fqdn, d.networkStrategy(network))
+	if err != nil {
+		return nil, err
+	}
+	return DialParallel(ctx, d.dialer, network, destination, addresses, d.strategy == DomainStrategyPreferIPv6, d.fallbackDelay)
+}
+
+func (d *ResolveDialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return d.ListenPacketNetwork(ctx, "udp", destination)
+}
+
+// ListenPacketNetwork is ListenPacket resolving only addresses of the family of network, which is "udp", "udp4" or "udp6".
+func (d *ResolveDialer) ListenPacketNetwork(ctx context.Context, network string, destination M.Socksaddr) (net.PacketConn, error) {
+	if !destination.IsFqdn() {
+		return d.dialer.ListenPacket(ctx, destination)
+	}
+	addresses, err := Lookup(ctx, d.resolver, destination.Fqdn, d.networkStrategy(network))
+	if err != nil {
+		return nil, err
+	}
+	return ListenSerial(ctx, d.dialer, destination, addresses)
+}
+
+func (d *ResolveDialer) Upstream() any {
+	return d.dialer
+}
+
+func (d *ResolveDialer) networkStrategy(network string) DomainStrategy {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return DomainStrategyUseIPv4
+	case strings.HasSuffix(network, "6"):
+		return DomainStrategyUseIPv6
+	default:
+		return d.strategy
+	}
+}
@@ -0,0 +1,47 @@
+package network_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+// fakeResolver resolves every domain to addresses, filtered by the requested family.
+type fakeResolver []netip.Addr
+
+func (r fakeResolver) LookupNetIP(ctx context.Context, network string, host string) ([]netip.Addr, error) {
+	var addresses []netip.Addr
+	for _, address := range r {
+		if network == "ip4" && !address.Is4() || network == "ip6" && !address.Is6() {
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func TestResolveDialerListenPacketNetwork(t *testing.T) {
+	resolver := fakeResolver{testAddr4a, testAddr6a}
+	destination := M.ParseSocksaddrHostPort("example.com", 53)
+	for _, test := range []struct {
+		network  string
+		expected []netip.Addr
+	}{
+		{"udp", []netip.Addr{testAddr6a, testAddr4a}},
+		{"udp4", []netip.Addr{testAddr4a}},
+		{"udp6", []netip.Addr{testAddr6a}},
+	} {
+		t.Run(test.network, func(t *testing.T) {
+			dialer := &fakeDialer{}
+			resolveDialer := N.NewResolveDialer(dialer, resolver, N.DomainStrategyPreferIPv6, 0)
+			_, err := resolveDialer.ListenPacketNetwork(context.Background(), test.network, destination)
+			if err == nil {
+				t.Fatal("listen succeeded with a failing dialer")
+			}
+			assertDialed(t, dialer.Dialed(), test.expected...)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	mRand "math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/MehranF123/sing/common"
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+	"github.com/MehranF123/sing/common/random"
+)
+
+// retryRand reads from crypto/rand, which is safe for concurrent use and needs no seed.
+var retryRand = mRand.New(random.Source{Reader: rand.Reader})
+
+const (
+	DefaultRetryInitialDelay = 100 * time.Millisecond
+	DefaultRetryMaxDelay     = 5 * time.Second
+	DefaultRetryMaxAttempts  = 5
+)
+
+// TransientError is implemented by errors that may not occur again on retry,
+// such as proxy replies for an unreachable destination.
+//
+// It is a dedicated method instead of Temporary, which is also implemented by net and syscall errors
+// that are not worth retrying, such as EMFILE.
+type TransientError interface {
+	error
+	Transient() bool
+}
+
+// IsTransientError reports whether err is a timeout, a refused, reset or unreachable connection,
+// or a TransientError that is transient.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if multiErr, isMulti := err.(E.MultiError); isMulti {
+		return common.Any(multiErr.UnwrapMulti(), IsTransientError)
+	}
+	if E.IsTimeout(err) || E.IsMulti(err, syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ENETUNREACH, syscall.EHOSTUNREACH) {
+		return true
+	}
+	if transientErr, isTransient := err.(TransientError); isTransient && transientErr.Transient() {
+		return true
+	}
+	return IsTransientError(errors.Unwrap(err))
+}
+
+type RetryOptions struct {
+	// MaxAttempts defaults to DefaultRetryMaxAttempts, a negative value retries until the context is done.
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// IsTransient defaults to IsTransientError.
+	IsTransient func(err error) bool
+}
+
+var _ Dialer = (*RetryDialer)(nil)
+
+// RetryDialer retries transient failures of dialer with exponential backoff and jitter.
+//
+// No attempt is started if the deadline of the context would pass before it.
+type RetryDialer struct {
+	dialer  Dialer
+	options RetryOptions
+}
+
+func NewRetryDialer(dialer Dialer, options RetryOptions) *RetryDialer {
+	if options.MaxAttempts == 0 {
+		options.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if options.InitialDelay <= 0 {
+		options.InitialDelay = DefaultRetryInitialDelay
+	}
+	if options.MaxDelay <= 0 {
+		options.MaxDelay = DefaultRetryMaxDelay
+	}
+	if options.MaxDelay < options.InitialDelay {
+		options.MaxDelay = options.InitialDelay
+	}
+	if options.IsTransient == nil {
+		options.IsTransient = IsTransientError
+	}
+	return &RetryDialer{
+		dialer:  dialer,
+		options: options,
+	}
+}
+
+func (d *RetryDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	return retry(ctx, d.options, func() (net.Conn, error) {
+		return d.dialer.DialContext(ctx, network, destination)
+	})
+}
+
+func (d *RetryDialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return retry(ctx, d.options, func() (net.PacketConn, error) {
+		return d.dialer.ListenPacket(ctx, destination)
+	})
+}
+
+func (d *RetryDialer) Upstream() any {
+	return d.dialer
+}
+
+func retry[T any](ctx context.Context, options RetryOptions, attempt func() (T, error)) (T, error) {
+	delay := options.InitialDelay
+	for attempts := 1; ; attempts++ {
+		result, err := attempt()
+		if err == nil {
+			return result, nil
+		}
+		if !options.IsTransient(err) || ctx.Err() != nil || attempts == options.MaxAttempts {
+			if attempts > 1 {
+				err = E.Cause(err, "retry: ", attempts, " attempts")
+			}
+			return result, err
+		}
+		// equal jitter keeps at least half of the delay
+		wait := delay/2 + time.Duration(retryRand.Int63n(int64(delay/2)+1))
+		if deadline, loaded := ctx.Deadline(); loaded && time.Now().Add(wait).After(deadline) {
+			return result, E.Cause(err, "retry: ", attempts, " attempts")
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return result, E.Cause(err, "retry: ", attempts, " attempts")
+		}
+		delay *= 2
+		if delay > options.MaxDelay {
+			delay = options.MaxDelay
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/MehranF123/sing/common"
 	"github.com/MehranF123/sing/common/buf"
+	"github.com/MehranF123/sing/common/bufio"
 	M "github.com/MehranF123/sing/common/metadata"
 )
 
@@ -35,16 +36,27 @@ func (c *ClientConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
 }
 
 func (c *ClientConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
-	defer buffer.Release()
-	err := AddrParser.WriteAddrPort(c, destination)
-	if err != nil {
-		return err
+	headerLen := AddrParser.AddrPortLen(destination) + 2
+	bufferLen := buffer.Len()
+	if buffer.Start() >= headerLen {
+		defer buffer.Release()
+		header := buf.With(buffer.ExtendHeader(headerLen))
+		err := AddrParser.WriteAddrPort(header, destination)
+		if err != nil {
+			return err
+		}
+		common.Must(binary.Write(header, binary.BigEndian, uint16(bufferLen)))
+		return common.Error(c.Conn.Write(buffer.Bytes()))
 	}
-	err = binary.Write(c, binary.BigEndian, uint16(buffer.Len()))
+	header := buf.NewSize(headerLen)
+	err := AddrParser.WriteAddrPort(header, destination)
 	if err != nil {
+		header.Release()
+		buffer.Release()
 		return err
 	}
-	return common.Error(c.Write(buffer.Bytes()))
+	common.Must(binary.Write(header, binary.BigEndian, uint16(bufferLen)))
+	return bufio.NewVectorisedWriter(c.Conn).WriteVectorised([]*buf.Buffer{header, buffer})
 }
 
 func (c *ClientConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
@@ -69,13 +81,19 @@ func (c *ClientConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 }
 
 func (c *ClientConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
-	err = AddrParser.WriteAddrPort(c, M.SocksaddrFromNet(addr))
+	destination := M.SocksaddrFromNet(addr)
+	_header := buf.StackNewSize(AddrParser.AddrPortLen(destination) + 2)
+	defer common.KeepAlive(_header)
+	header := common.Dup(_header)
+	defer header.Release()
+	err = AddrParser.WriteAddrPort(header, destination)
 	if err != nil {
 		return
 	}
-	err = binary.Write(c, binary.BigEndian, uint16(len(p)))
-	if err != nil {
-		return
+	common.Must(binary.Write(header, binary.BigEndian, uint16(len(p))))
+	_, err = bufio.WriteVectorised(bufio.NewVectorisedWriter(c.Conn), [][]byte{header.Bytes(), p})
+	if err == nil {
+		n = len(p)
 	}
-	return c.Write(p)
+	return
 }
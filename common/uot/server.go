@@ -1,6 +1,7 @@
 package uot
 
 import (
+	"context"
 	"encoding/binary"
 	"io"
 	"net"
@@ -8,17 +9,31 @@ import (
 	"github.com/MehranF123/sing/common"
 	"github.com/MehranF123/sing/common/buf"
 	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
 )
 
 type ServerConn struct {
 	net.PacketConn
 	inputReader, outputReader *io.PipeReader
 	inputWriter, outputWriter *io.PipeWriter
+	resolver                  N.Resolver
+	ctx                       context.Context
+	cancel                    context.CancelFunc
 }
 
 func NewServerConn(packetConn net.PacketConn) net.Conn {
+	return NewServerConnWithResolver(context.Background(), packetConn, N.SystemResolver)
+}
+
+// NewServerConnWithResolver creates a server conn resolving domain destinations with resolver,
+// lookups are cancelled with ctx or when the conn is closed.
+func NewServerConnWithResolver(ctx context.Context, packetConn net.PacketConn, resolver N.Resolver) net.Conn {
+	ctx, cancel := context.WithCancel(ctx)
 	c := &ServerConn{
 		PacketConn: packetConn,
+		resolver:   resolver,
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 	c.inputReader, c.inputWriter = io.Pipe()
 	c.outputReader, c.outputWriter = io.Pipe()
@@ -55,11 +70,14 @@ func (c *ServerConn) loopInput() {
 			break
 		}
 		if destination.IsFqdn() {
-			addr, err := net.ResolveUDPAddr("udp", destination.String())
+			addresses, err := N.Lookup(c.ctx, c.resolver, destination.Fqdn, N.DomainStrategyAsIS)
 			if err != nil {
+				if c.ctx.Err() != nil {
+					break
+				}
 				continue
 			}
-			destination = M.SocksaddrFromNet(addr)
+			destination = M.SocksaddrFrom(addresses[0], destination.Port)
 		}
 		var length uint16
 		err = binary.Read(c.inputReader, binary.BigEndian, &length)
@@ -107,6 +125,7 @@ func (c *ServerConn) loopOutput() {
 }
 
 func (c *ServerConn) Close() error {
+	c.cancel()
 	c.inputReader.Close()
 	c.inputWriter.Close()
 	c.outputReader.Close()
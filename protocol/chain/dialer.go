@@ -0,0 +1,87 @@
+package chain
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+
+	E "github.com/MehranF123/sing/common/exceptions"
+	F "github.com/MehranF123/sing/common/format"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+	"github.com/MehranF123/sing/protocol/http"
+	"github.com/MehranF123/sing/protocol/socks"
+	"github.com/MehranF123/sing/protocol/trojan"
+)
+
+var _ N.Dialer = (*Dialer)(nil)
+
+// Dialer connects through a chain of proxies.
+type Dialer struct {
+	dialer N.Dialer
+	udpErr error
+}
+
+// NewDialer creates a client for each proxy URL, the first one dials with dialer and each later one through the previous client.
+//
+// Supported schemes are socks, socks4, socks4a, socks5, http and trojan.
+// UDP is relayed only if the last hop supports it, and for socks5 if every previous hop supports it too.
+func NewDialer(dialer N.Dialer, proxyURLs ...string) (*Dialer, error) {
+	if len(proxyURLs) == 0 {
+		return nil, E.New("proxy chain: empty chain")
+	}
+	var udpErr error
+	for index, rawURL := range proxyURLs {
+		proxyURL, err := url.Parse(rawURL)
+		if err != nil {
+			// the error of url.Parse contains the url with its credentials
+			if urlErr, isURLErr := err.(*url.Error); isURLErr {
+				err = urlErr.Err
+			}
+			return nil, E.Cause(err, "proxy chain: hop ", index+1, ": parse url")
+		}
+		// trojan keeps its password in the user name, so the credentials are left out entirely
+		hopName := F.ToString("proxy chain: hop ", index+1, " (", proxyURL.Scheme, "://", proxyURL.Host, ")")
+		switch proxyURL.Scheme {
+		case "socks", "socks5":
+			dialer, err = socks.NewClientFromURL(dialer, rawURL)
+		case "socks4", "socks4a":
+			dialer, err = socks.NewClientFromURL(dialer, rawURL)
+			udpErr = E.New(hopName, ": socks4 does not support udp")
+		case "http":
+			dialer, err = http.NewClientFromURL(dialer, rawURL)
+			udpErr = E.New(hopName, ": http proxy does not support udp")
+		case "trojan":
+			dialer, err = trojan.NewClientFromURL(dialer, rawURL)
+			// trojan relays udp over its tcp connection
+			udpErr = nil
+		case "":
+			return nil, E.New(hopName, ": missing scheme")
+		default:
+			return nil, E.New(hopName, ": unsupported scheme: ", proxyURL.Scheme)
+		}
+		if err != nil {
+			return nil, E.Cause(err, hopName)
+		}
+	}
+	return &Dialer{dialer, udpErr}, nil
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if strings.HasPrefix(network, "udp") && d.udpErr != nil {
+		return nil, d.udpErr
+	}
+	return d.dialer.DialContext(ctx, network, destination)
+}
+
+func (d *Dialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	if d.udpErr != nil {
+		return nil, d.udpErr
+	}
+	return d.dialer.ListenPacket(ctx, destination)
+}
+
+func (d *Dialer) Upstream() any {
+	return d.dialer
+}
@@ -0,0 +1,36 @@
+package chain_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+	"github.com/MehranF123/sing/protocol/chain"
+)
+
+const testPassword = "hunter2-secret"
+
+func TestDialerErrorRedacted(t *testing.T) {
+	for _, proxyURLs := range [][]string{
+		{"trojan://" + testPassword + "@example.com:443?security=unknown"},
+		{"trojan://" + testPassword + "@example.com:443", "ftp://example.org"},
+		{"socks5://user:" + testPassword + "@example.com:1080", "socks4://" + testPassword + "@example.org:1080"},
+		{"http://user:" + testPassword + "@example.com:8080", "unknown://" + testPassword + "@example.org"},
+	} {
+		dialer, err := chain.NewDialer(N.SystemDialer, proxyURLs...)
+		if err == nil {
+			_, err = dialer.ListenPacket(context.Background(), M.ParseSocksaddr("1.1.1.1:53"))
+		}
+		if err == nil {
+			t.Fatal("chain ", len(proxyURLs), " hops: expected error")
+		}
+		if strings.Contains(err.Error(), testPassword) {
+			t.Fatal("error contains the password: ", err)
+		}
+		if !strings.Contains(err.Error(), "example.") {
+			t.Fatal("error does not name the hop: ", err)
+		}
+	}
+}
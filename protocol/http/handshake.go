@@ -75,7 +75,7 @@ func HandleConnection(ctx context.Context, conn net.Conn, reader *std_bufio.Read
 			} else {
 				requestConn = conn
 			}
-			return handler.NewConnection(ctx, requestConn, metadata)
+			return handler.NewConnection(M.ContextWithMetadata(ctx, metadata), requestConn, metadata)
 		}
 
 		keepAlive := !(request.ProtoMajor == 1 && request.ProtoMinor == 0) && strings.TrimSpace(strings.ToLower(request.Header.Get("Proxy-Connection"))) == "keep-alive"
@@ -102,9 +102,9 @@ func HandleConnection(ctx context.Context, conn net.Conn, reader *std_bufio.Read
 						}
 						metadata.Destination = M.ParseSocksaddr(address)
 						metadata.Protocol = "http"
-						left, right := net.Pipe()
+						left, right := bufio.Pipe()
 						go func() {
-							err := handler.NewConnection(ctx, right, metadata)
+							err := handler.NewConnection(M.ContextWithMetadata(ctx, metadata), right, metadata)
 							if err != nil {
 								innerErr = err
 								common.Close(left, right)
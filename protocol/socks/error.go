@@ -0,0 +1,37 @@
+package socks
+
+import (
+	E "github.com/MehranF123/sing/common/exceptions"
+	F "github.com/MehranF123/sing/common/format"
+	"github.com/MehranF123/sing/protocol/socks/socks5"
+)
+
+var ErrAuthenticationFailed = E.New("socks5: incorrect user name or password")
+
+// RequestRejectedError is returned by the client handshakes when the server replies with a failure code.
+type RequestRejectedError struct {
+	Version   Version
+	ReplyCode byte
+}
+
+func (e *RequestRejectedError) Error() string {
+	if e.Version == Version5 {
+		return F.ToString("socks5: request rejected, code=", e.ReplyCode)
+	}
+	return F.ToString("socks4: request rejected, code=", e.ReplyCode)
+}
+
+// Transient reports whether the server failed to reach the destination, which may succeed on retry.
+func (e *RequestRejectedError) Transient() bool {
+	if e.Version != Version5 {
+		// socks4 replies the same code for policy rejections and connection failures
+		return false
+	}
+	switch e.ReplyCode {
+	case socks5.ReplyCodeFailure, socks5.ReplyCodeNetworkUnreachable, socks5.ReplyCodeHostUnreachable,
+		socks5.ReplyCodeConnectionRefused, socks5.ReplyCodeTTLExpired:
+		return true
+	default:
+		return false
+	}
+}
@@ -23,6 +23,11 @@ type Handler interface {
 }
 
 func ClientHandshake4(conn io.ReadWriter, command byte, destination M.Socksaddr, username string) (socks4.Response, error) {
+	return clientHandshake4(conn, command, destination, username, nil)
+}
+
+// clientHandshake4 writes earlyData after the request without waiting for the response.
+func clientHandshake4(conn io.ReadWriter, command byte, destination M.Socksaddr, username string, earlyData []byte) (socks4.Response, error) {
 	err := socks4.WriteRequest(conn, socks4.Request{
 		Command:     command,
 		Destination: destination,
@@ -31,17 +36,28 @@ func ClientHandshake4(conn io.ReadWriter, command byte, destination M.Socksaddr,
 	if err != nil {
 		return socks4.Response{}, err
 	}
+	if len(earlyData) > 0 {
+		_, err = conn.Write(earlyData)
+		if err != nil {
+			return socks4.Response{}, err
+		}
+	}
 	response, err := socks4.ReadResponse(conn)
 	if err != nil {
 		return socks4.Response{}, err
 	}
 	if response.ReplyCode != socks4.ReplyCodeGranted {
-		err = E.New("socks4: request rejected, code= ", response.ReplyCode)
+		err = &RequestRejectedError{Version4, response.ReplyCode}
 	}
 	return response, err
 }
 
 func ClientHandshake5(conn io.ReadWriter, command byte, destination M.Socksaddr, username string, password string) (socks5.Response, error) {
+	return clientHandshake5(conn, command, destination, username, password, nil)
+}
+
+// clientHandshake5 writes earlyData after the request without waiting for the response.
+func clientHandshake5(conn io.ReadWriter, command byte, destination M.Socksaddr, username string, password string, earlyData []byte) (socks5.Response, error) {
 	var method byte
 	if username == "" {
 		method = socks5.AuthTypeNotRequired
@@ -71,7 +87,7 @@ func ClientHandshake5(conn io.ReadWriter, command byte, destination M.Socksaddr,
 			return socks5.Response{}, err
 		}
 		if usernamePasswordResponse.Status != socks5.UsernamePasswordStatusSuccess {
-			return socks5.Response{}, E.New("socks5: incorrect user name or password")
+			return socks5.Response{}, ErrAuthenticationFailed
 		}
 	} else if authResponse.Method != socks5.AuthTypeNotRequired {
 		return socks5.Response{}, E.New("socks5: unsupported auth method: ", authResponse.Method)
@@ -83,12 +99,18 @@ func ClientHandshake5(conn io.ReadWriter, command byte, destination M.Socksaddr,
 	if err != nil {
 		return socks5.Response{}, err
 	}
+	if len(earlyData) > 0 {
+		_, err = conn.Write(earlyData)
+		if err != nil {
+			return socks5.Response{}, err
+		}
+	}
 	response, err := socks5.ReadResponse(conn)
 	if err != nil {
 		return socks5.Response{}, err
 	}
 	if response.ReplyCode != socks5.ReplyCodeSuccess {
-		err = E.New("socks5: request rejected, code=", response.ReplyCode)
+		err = &RequestRejectedError{Version5, response.ReplyCode}
 	}
 	return response, err
 }
@@ -123,7 +145,7 @@ func HandleConnection0(ctx context.Context, conn net.Conn, version byte, authent
 			}
 			metadata.Protocol = "socks4"
 			metadata.Destination = request.Destination
-			return handler.NewConnection(auth.ContextWithUser(ctx, request.Username), conn, metadata)
+			return handler.NewConnection(M.ContextWithMetadata(auth.ContextWithUser(ctx, request.Username), metadata), conn, metadata)
 		default:
 			err = socks4.WriteResponse(conn, socks4.Response{
 				ReplyCode:   socks4.ReplyCodeRejectedOrFailed,
@@ -191,7 +213,7 @@ func HandleConnection0(ctx context.Context, conn net.Conn, version byte, authent
 			}
 			metadata.Protocol = "socks5"
 			metadata.Destination = request.Destination
-			return handler.NewConnection(ctx, conn, metadata)
+			return handler.NewConnection(M.ContextWithMetadata(ctx, metadata), conn, metadata)
 		case socks5.CommandUDPAssociate:
 			var udpConn *net.UDPConn
 			udpConn, err = net.ListenUDP(M.NetworkFromNetAddr("udp", M.AddrFromNetAddr(conn.LocalAddr())), net.UDPAddrFromAddrPort(netip.AddrPortFrom(M.AddrFromNetAddr(conn.LocalAddr()), 0)))
@@ -212,7 +234,7 @@ func HandleConnection0(ctx context.Context, conn net.Conn, version byte, authent
 			done := make(chan struct{})
 			go func() {
 				defer conn.Close()
-				innerError = handler.NewPacketConnection(ctx, NewAssociatePacketConn(udpConn, request.Destination, conn), metadata)
+				innerError = handler.NewPacketConnection(M.ContextWithMetadata(ctx, metadata), NewAssociatePacketConn(udpConn, request.Destination, conn), metadata)
 				close(done)
 			}()
 			err = common.Error(io.Copy(io.Discard, conn))
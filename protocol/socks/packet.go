@@ -98,11 +98,26 @@ func (c *AssociatePacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error
 }
 
 func (c *AssociatePacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
-	defer buffer.Release()
-	header := buf.With(buffer.ExtendHeader(3 + M.SocksaddrSerializer.AddrPortLen(destination)))
+	headerLen := 3 + M.SocksaddrSerializer.AddrPortLen(destination)
+	if buffer.Start() >= headerLen {
+		defer buffer.Release()
+		header := buf.With(buffer.ExtendHeader(headerLen))
+		common.Must(header.WriteZeroN(3))
+		err := M.SocksaddrSerializer.WriteAddrPort(header, destination)
+		if err != nil {
+			return err
+		}
+		return common.Error(bufio.WriteTo(c.PacketConn, buffer, c.addr))
+	}
+	header := buf.NewSize(headerLen)
 	common.Must(header.WriteZeroN(3))
-	common.Must(M.SocksaddrSerializer.WriteAddrPort(header, destination))
-	return common.Error(bufio.WriteTo(c.PacketConn, buffer, c.addr))
+	err := M.SocksaddrSerializer.WriteAddrPort(header, destination)
+	if err != nil {
+		header.Release()
+		buffer.Release()
+		return err
+	}
+	return bufio.NewVectorisedPacketWriter(c.PacketConn).WriteVectorisedPacket([]*buf.Buffer{header, buffer}, M.SocksaddrFromNet(c.addr))
 }
 
 func (c *AssociatePacketConn) Upstream() any {
@@ -0,0 +1,168 @@
+package trojan
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/MehranF123/sing/common/buf"
+	"github.com/MehranF123/sing/common/bufio"
+	E "github.com/MehranF123/sing/common/exceptions"
+	M "github.com/MehranF123/sing/common/metadata"
+	N "github.com/MehranF123/sing/common/network"
+)
+
+var _ N.Dialer = (*Client)(nil)
+
+type Client struct {
+	dialer     N.Dialer
+	serverAddr M.Socksaddr
+	key        [KeyLength]byte
+	tlsConfig  *tls.Config
+	coalescing bool
+}
+
+// NewClient creates a client connecting to serverAddr with TLS, or in plain text if tlsConfig is nil.
+func NewClient(dialer N.Dialer, serverAddr M.Socksaddr, password string, tlsConfig *tls.Config) *Client {
+	return &Client{
+		dialer:     dialer,
+		serverAddr: serverAddr,
+		key:        Key(password),
+		tlsConfig:  tlsConfig,
+	}
+}
+
+// NewClientFromURL creates a client from a URL like trojan://password@host:port?sni=name, the port defaults to 443.
+//
+// The query may set allowInsecure=1 to skip certificate verification and security=none to disable TLS.
+func NewClientFromURL(dialer N.Dialer, rawURL string) (*Client, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "trojan://" + rawURL
+	}
+	serverURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if serverURL.Scheme != "trojan" {
+		return nil, E.New("trojan: unknown scheme: ", serverURL.Scheme)
+	}
+	if serverURL.User == nil || serverURL.User.Username() == "" {
+		return nil, E.New("trojan: missing password")
+	}
+	port := serverURL.Port()
+	if port == "" {
+		port = "443"
+	}
+	query := serverURL.Query()
+	var tlsConfig *tls.Config
+	switch query.Get("security") {
+	case "", "tls":
+		tlsConfig = &tls.Config{
+			ServerName:         serverURL.Hostname(),
+			InsecureSkipVerify: query.Get("allowInsecure") == "1" || query.Get("allowInsecure") == "true",
+		}
+		if sni := query.Get("sni"); sni != "" {
+			tlsConfig.ServerName = sni
+		}
+	case "none":
+	default:
+		return nil, E.New("trojan: unknown security: ", query.Get("security"))
+	}
+	return NewClient(dialer, M.ParseSocksaddrHostPortStr(serverURL.Hostname(), port), serverURL.User.Username(), tlsConfig), nil
+}
+
+// SetCoalescing makes the client hold the request header and small writes until the first read,
+// so they reach the server together with the first payload.
+func (c *Client) SetCoalescing(enabled bool) {
+	c.coalescing = enabled
+}
+
+func (c *Client) dialServer(ctx context.Context) (net.Conn, error) {
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	if c.tlsConfig == nil {
+		return conn, nil
+	}
+	tlsConn := tls.Client(conn, c.tlsConfig)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, E.Cause(err, "tls handshake")
+	}
+	return tlsConn, nil
+}
+
+// DialContext returns a connection sending the request header with its first write.
+func (c *Client) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") && !strings.HasPrefix(network, "udp") {
+		return nil, os.ErrInvalid
+	}
+	conn, err := c.dialServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(network, "udp") {
+		return &clientBindPacketConn{NewClientPacketConn(conn, c.key), destination}, nil
+	}
+	if c.coalescing {
+		conn = bufio.NewEarlyDataConn(conn)
+	}
+	return NewClientConn(conn, c.key, destination), nil
+}
+
+// DialContextWithEarlyData is DialContext writing the request header and earlyData immediately.
+func (c *Client) DialContextWithEarlyData(ctx context.Context, network string, destination M.Socksaddr, earlyData []byte) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, E.New("trojan: early data is only supported for tcp")
+	}
+	conn, err := c.DialContext(ctx, network, destination)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write(earlyData)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ListenPacket returns a packet conn relaying UDP over a TCP connection to the server.
+func (c *Client) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	conn, err := c.dialServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientPacketConn(conn, c.key), nil
+}
+
+type clientBindPacketConn struct {
+	*ClientPacketConn
+	destination M.Socksaddr
+}
+
+func (c *clientBindPacketConn) Read(p []byte) (n int, err error) {
+	n, _, err = c.ReadFrom(p)
+	return
+}
+
+func (c *clientBindPacketConn) Write(p []byte) (n int, err error) {
+	err = c.WritePacket(buf.As(p), c.destination)
+	if err == nil {
+		n = len(p)
+	}
+	return
+}
+
+func (c *clientBindPacketConn) RemoteAddr() net.Addr {
+	return c.destination
+}
+
+func (c *clientBindPacketConn) Upstream() any {
+	return c.ClientPacketConn
+}
@@ -19,6 +19,9 @@ const (
 	KeyLength  = 56
 	CommandTCP = 1
 	CommandUDP = 3
+
+	// maxRequestHeaderLength is the length of the key, crlf, command, a domain address with port and crlf.
+	maxRequestHeaderLength = KeyLength + 2 + 1 + 1 + 1 + 255 + 2 + 2
 )
 
 var CRLF = []byte{'\r', '\n'}
@@ -93,8 +96,8 @@ func (c *ClientPacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
 }
 
 func (c *ClientPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
-	defer buffer.Release()
 	if !c.headerWritten {
+		defer buffer.Release()
 		err := ClientHandshakePacket(c.Conn, c.key, destination, buffer)
 		c.headerWritten = true
 		return err
@@ -114,7 +117,7 @@ func (c *ClientPacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error)
 }
 
 func (c *ClientPacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
-	err = c.WritePacket(buf.With(p), M.SocksaddrFromNet(addr))
+	err = c.WritePacket(buf.As(p), M.SocksaddrFromNet(addr))
 	if err == nil {
 		n = len(p)
 	}
@@ -129,126 +132,95 @@ func Key(password string) [KeyLength]byte {
 	return key
 }
 
-func ClientHandshakeRaw(conn net.Conn, key [KeyLength]byte, command byte, destination M.Socksaddr, payload []byte) error {
-	_, err := conn.Write(key[:])
-	if err != nil {
-		return err
-	}
-	_, err = conn.Write(CRLF)
-	if err != nil {
-		return err
-	}
-	_, err = conn.Write([]byte{command})
+func writeRequestHeader(header *buf.Buffer, key [KeyLength]byte, command byte, destination M.Socksaddr) error {
+	common.Must1(header.Write(key[:]))
+	common.Must1(header.Write(CRLF))
+	common.Must(header.WriteByte(command))
+	err := M.SocksaddrSerializer.WriteAddrPort(header, destination)
 	if err != nil {
 		return err
 	}
-	err = M.SocksaddrSerializer.WriteAddrPort(conn, destination)
+	common.Must1(header.Write(CRLF))
+	return nil
+}
+
+func ClientHandshakeRaw(conn net.Conn, key [KeyLength]byte, command byte, destination M.Socksaddr, payload []byte) error {
+	_header := buf.StackNewSize(KeyLength + M.SocksaddrSerializer.AddrPortLen(destination) + 5)
+	defer common.KeepAlive(_header)
+	header := common.Dup(_header)
+	defer header.Release()
+	err := writeRequestHeader(header, key, command, destination)
 	if err != nil {
 		return err
 	}
-	_, err = conn.Write(CRLF)
+	return common.Error(bufio.WriteVectorised(bufio.NewVectorisedWriter(conn), [][]byte{header.Bytes(), payload}))
+}
+
+func ClientHandshake(conn net.Conn, key [KeyLength]byte, destination M.Socksaddr, payload []byte) error {
+	err := ClientHandshakeRaw(conn, key, CommandTCP, destination, payload)
 	if err != nil {
-		return err
-	}
-	if len(payload) > 0 {
-		_, err = conn.Write(payload)
-		if err != nil {
-			return err
-		}
+		return E.Cause(err, "write request")
 	}
 	return nil
 }
 
-func ClientHandshake(conn net.Conn, key [KeyLength]byte, destination M.Socksaddr, payload []byte) error {
+// ClientHandshakeBuffer writes the request header and payload, which is not released.
+func ClientHandshakeBuffer(conn net.Conn, key [KeyLength]byte, destination M.Socksaddr, payload *buf.Buffer) error {
 	headerLen := KeyLength + M.SocksaddrSerializer.AddrPortLen(destination) + 5
 	var header *buf.Buffer
-	defer header.Release()
-	var writeHeader bool
-	if len(payload) > 0 && headerLen+len(payload) < 65535 {
-		buffer := buf.StackNewSize(headerLen + len(payload))
-		defer common.KeepAlive(buffer)
-		header = common.Dup(buffer)
+	extended := payload.Start() >= headerLen
+	if extended {
+		header = buf.With(payload.ExtendHeader(headerLen))
 	} else {
-		buffer := buf.StackNewSize(headerLen)
-		defer common.KeepAlive(buffer)
-		header = common.Dup(buffer)
-		writeHeader = true
-	}
-	common.Must1(header.Write(key[:]))
-	common.Must1(header.Write(CRLF))
-	common.Must(header.WriteByte(CommandTCP))
-	common.Must(M.SocksaddrSerializer.WriteAddrPort(header, destination))
-	common.Must1(header.Write(CRLF))
-	if !writeHeader {
-		common.Must1(header.Write(payload))
+		header = buf.NewSize(headerLen)
+		defer header.Release()
 	}
-
-	_, err := conn.Write(header.Bytes())
+	err := writeRequestHeader(header, key, CommandTCP, destination)
 	if err != nil {
-		return E.Cause(err, "write request")
-	}
-
-	if writeHeader {
-		_, err = conn.Write(payload)
-		if err != nil {
-			return E.Cause(err, "write payload")
-		}
+		return err
 	}
-	return nil
-}
-
-func ClientHandshakeBuffer(conn net.Conn, key [KeyLength]byte, destination M.Socksaddr, payload *buf.Buffer) error {
-	header := buf.With(payload.ExtendHeader(KeyLength + M.SocksaddrSerializer.AddrPortLen(destination) + 5))
-	common.Must1(header.Write(key[:]))
-	common.Must1(header.Write(CRLF))
-	common.Must(header.WriteByte(CommandTCP))
-	common.Must(M.SocksaddrSerializer.WriteAddrPort(header, destination))
-	common.Must1(header.Write(CRLF))
-
-	_, err := conn.Write(payload.Bytes())
+	err = writeHeaderAndPayload(conn, header, payload, extended)
 	if err != nil {
 		return E.Cause(err, "write request")
 	}
 	return nil
 }
 
+// ClientHandshakePacket writes the request header and the first packet, which is not released.
 func ClientHandshakePacket(conn net.Conn, key [KeyLength]byte, destination M.Socksaddr, payload *buf.Buffer) error {
 	headerLen := KeyLength + 2*M.SocksaddrSerializer.AddrPortLen(destination) + 9
 	payloadLen := payload.Len()
 	var header *buf.Buffer
-	defer header.Release()
-	var writeHeader bool
-	if payload.Start() >= headerLen {
+	extended := payload.Start() >= headerLen
+	if extended {
 		header = buf.With(payload.ExtendHeader(headerLen))
 	} else {
-		buffer := buf.StackNewSize(headerLen)
-		defer common.KeepAlive(buffer)
-		header = common.Dup(buffer)
-		writeHeader = true
+		header = buf.NewSize(headerLen)
+		defer header.Release()
+	}
+	err := writeRequestHeader(header, key, CommandUDP, destination)
+	if err != nil {
+		return err
 	}
-	common.Must1(header.Write(key[:]))
-	common.Must1(header.Write(CRLF))
-	common.Must(header.WriteByte(CommandUDP))
-	common.Must(M.SocksaddrSerializer.WriteAddrPort(header, destination))
-	common.Must1(header.Write(CRLF))
 	common.Must(M.SocksaddrSerializer.WriteAddrPort(header, destination))
 	common.Must(binary.Write(header, binary.BigEndian, uint16(payloadLen)))
 	common.Must1(header.Write(CRLF))
-
-	if writeHeader {
-		_, err := conn.Write(header.Bytes())
-		if err != nil {
-			return E.Cause(err, "write request")
-		}
-	}
-
-	_, err := conn.Write(payload.Bytes())
+	err = writeHeaderAndPayload(conn, header, payload, extended)
 	if err != nil {
-		return E.Cause(err, "write payload")
+		return E.Cause(err, "write request")
 	}
 	return nil
 }
 
+// writeHeaderAndPayload writes payload with a single write if header was extended into it,
+// or both with a vectorised write otherwise. Neither buffer is released.
+func writeHeaderAndPayload(conn net.Conn, header *buf.Buffer, payload *buf.Buffer, extended bool) error {
+	if extended {
+		return common.Error(conn.Write(payload.Bytes()))
+	}
+	return bufio.NewVectorisedWriter(conn).WriteVectorised([]*buf.Buffer{buf.As(header.Bytes()), buf.As(payload.Bytes())})
+}
+
 func ReadPacket(conn net.Conn, buffer *buf.Buffer) (M.Socksaddr, error) {
 	destination, err := M.SocksaddrSerializer.ReadAddrPort(conn)
 	if err != nil {
@@ -274,14 +246,26 @@ func ReadPacket(conn net.Conn, buffer *buf.Buffer) (M.Socksaddr, error) {
 	return destination, err
 }
 
+// WritePacket writes a packet and releases buffer.
 func WritePacket(conn net.Conn, buffer *buf.Buffer, destination M.Socksaddr) error {
 	defer buffer.Release()
+	headerLen := M.SocksaddrSerializer.AddrPortLen(destination) + 4
 	bufferLen := buffer.Len()
-	header := buf.With(buffer.ExtendHeader(M.SocksaddrSerializer.AddrPortLen(destination) + 4))
-	common.Must(M.SocksaddrSerializer.WriteAddrPort(header, destination))
+	var header *buf.Buffer
+	extended := buffer.Start() >= headerLen
+	if extended {
+		header = buf.With(buffer.ExtendHeader(headerLen))
+	} else {
+		header = buf.NewSize(headerLen)
+		defer header.Release()
+	}
+	err := M.SocksaddrSerializer.WriteAddrPort(header, destination)
+	if err != nil {
+		return err
+	}
 	common.Must(binary.Write(header, binary.BigEndian, uint16(bufferLen)))
 	common.Must1(header.Write(CRLF))
-	_, err := conn.Write(buffer.Bytes())
+	err = writeHeaderAndPayload(conn, header, buffer, extended)
 	if err != nil {
 		return E.Cause(err, "write packet")
 	}
@@ -8,6 +8,7 @@ import (
 	"github.com/MehranF123/sing/common"
 	"github.com/MehranF123/sing/common/auth"
 	"github.com/MehranF123/sing/common/buf"
+	"github.com/MehranF123/sing/common/bufio"
 	E "github.com/MehranF123/sing/common/exceptions"
 	F "github.com/MehranF123/sing/common/format"
 	M "github.com/MehranF123/sing/common/metadata"
@@ -64,15 +65,24 @@ func (s *Service[K]) ResetUsers() {
 }
 
 func (s *Service[K]) NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error {
+	rewindConn := bufio.NewRewindConnSize(conn, maxRequestHeaderLength)
+	conn = rewindConn
+
 	var key [KeyLength]byte
 	_, err := io.ReadFull(conn, common.Dup(key[:]))
 	if err != nil {
-		return err
+		err = E.Cause(err, "read key")
+		goto returnErr
 	}
 
 	goto process
 
 returnErr:
+	// hand the untouched stream to the caller, so it can fall back to another handler
+	if rewindErr := rewindConn.Rewind(); rewindErr != nil {
+		err = E.Errors(err, rewindErr)
+	}
+	rewindConn.StopRecording()
 	err = &Error{
 		Metadata: metadata,
 		Conn:     conn,
@@ -118,9 +128,12 @@ process:
 		goto returnErr
 	}
 
+	rewindConn.StopRecording()
+
 	metadata.Protocol = "trojan"
 	metadata.Destination = destination
 
+	ctx = M.ContextWithMetadata(ctx, metadata)
 	if command == CommandTCP {
 		return s.handler.NewConnection(ctx, conn, metadata)
 	} else {